@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"moneyjar/pkg/config"
+	"moneyjar/pkg/database"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// moveledger hands the chat ledger over to another chat, e.g. the default ledger with data recorded before
+// ledgers were split by chat to the chat which used the bot back then
+func main() {
+	configPath := flag.String("config", "config.yaml", "Path to yaml config file")
+	fromChatID := flag.Int64("from", 0, "Telegram chat id of the ledger, 0 is ledger of data created before chats support")
+	toChatID := flag.Int64("to", 0, "Telegram chat id the ledger is moved to, the chat must have no members yet")
+	flag.Parse()
+
+	if *fromChatID == *toChatID {
+		flag.Usage()
+		log.Fatal("chat to move ledger to is required and must differ from the source one")
+	}
+
+	if err := config.Load(*configPath); err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	db, err := database.New(config.C.String("db.dsn"))
+	if err != nil {
+		log.Fatalf("failed to connect to database: %v", err)
+	}
+
+	if err = db.MoveLedger(context.Background(), *fromChatID, *toChatID); err != nil {
+		log.Fatalf("failed to move ledger: %v", err)
+	}
+	log.Infof("ledger of chat %d moved to chat %d", *fromChatID, *toChatID)
+}
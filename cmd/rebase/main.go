@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"moneyjar/pkg/config"
+	"moneyjar/pkg/database"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// rebase converts balances and transaction log of the chat ledger to another base currency at the given rate
+func main() {
+	configPath := flag.String("config", "config.yaml", "Path to yaml config file")
+	chatID := flag.Int64("chat", 0, "Telegram chat id of the ledger, 0 is ledger of data created before chats support")
+	code := flag.String("currency", "", "Code of the new base currency, e.g. GEL")
+	decimals := flag.Int("decimals", 2, "Number of minor unit digits of the new base currency")
+	rate := flag.Float64("rate", 0, "Amount of the new base currency for one unit of the current one")
+	flag.Parse()
+
+	if len(*code) != 3 || *rate <= 0 || *decimals < 0 {
+		flag.Usage()
+		log.Fatal("currency code, positive rate and non-negative decimals are required")
+	}
+
+	if err := config.Load(*configPath); err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	db, err := database.New(config.C.String("db.dsn"))
+	if err != nil {
+		log.Fatalf("failed to connect to database: %v", err)
+	}
+
+	ctx := context.Background()
+	current, err := db.GetLedger(ctx, *chatID)
+	if err != nil {
+		log.Fatalf("failed to get ledger of chat %d: %v", *chatID, err)
+	}
+
+	ledger := database.Ledger{ChatID: *chatID, BaseCurrency: strings.ToUpper(*code), BaseDecimals: *decimals}
+	if err = db.RebaseLedger(ctx, ledger, *rate); err != nil {
+		log.Fatalf("failed to rebase ledger: %v", err)
+	}
+	log.Infof("ledger of chat %d rebased from %s to %s at rate %f", *chatID, current.BaseCurrency, ledger.BaseCurrency, *rate)
+}
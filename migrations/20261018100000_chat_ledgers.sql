@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+create table chat_members (
+    chat_id bigint not null,
+    user_id int references users(id),
+    primary key (chat_id, user_id)
+);
+
+-- Everything recorded before ledgers were split by chat goes to the default ledger with chat_id = 0.
+-- It's handed over to a real chat with cmd/moveledger, e.g. go run ./cmd/moveledger -to <chat id>.
+insert into chat_members (chat_id, user_id) select 0, id from users;
+
+alter table accounts
+    add column chat_id bigint not null default 0;
+alter table accounts
+    alter column chat_id drop default;
+create index accounts_chat_id_idx on accounts (chat_id);
+
+alter table transactionlog
+    add column chat_id bigint not null default 0;
+alter table transactionlog
+    alter column chat_id drop default;
+create index transactionlog_chat_id_idx on transactionlog (chat_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop index transactionlog_chat_id_idx;
+alter table transactionlog
+    drop column chat_id;
+drop index accounts_chat_id_idx;
+alter table accounts
+    drop column chat_id;
+drop table chat_members;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+alter table transactionlog
+    add column kind text not null default 'expense';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table transactionlog
+    drop column kind;
+-- +goose StatementEnd
@@ -0,0 +1,38 @@
+-- +goose Up
+-- +goose StatementBegin
+alter table transactionlog
+    add column id bigserial primary key;
+
+-- Operation groups records written by single command, its ID is taken from the same sequence as record IDs.
+-- Old records were written one per command, so every record becomes an operation of its own.
+alter table transactionlog
+    add column operation_id bigint;
+update transactionlog set operation_id = id;
+alter table transactionlog
+    alter column operation_id set not null;
+create index transactionlog_operation_id_idx on transactionlog (chat_id, operation_id);
+
+alter table transactionlog
+    add column author_id int references users(id);
+update transactionlog set author_id = from_user;
+alter table transactionlog
+    alter column author_id set not null;
+
+alter table transactionlog
+    add column reverts bigint;
+create index transactionlog_reverts_idx on transactionlog (reverts);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop index transactionlog_reverts_idx;
+alter table transactionlog
+    drop column reverts;
+alter table transactionlog
+    drop column author_id;
+drop index transactionlog_operation_id_idx;
+alter table transactionlog
+    drop column operation_id;
+alter table transactionlog
+    drop column id;
+-- +goose StatementEnd
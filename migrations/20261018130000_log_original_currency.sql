@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Records written before this migration have only converted USD amounts, so original values stay empty
+alter table transactionlog
+    add column original_amount numeric,
+    add column currency text,
+    add column exchange_rate numeric;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table transactionlog
+    drop column original_amount,
+    drop column currency,
+    drop column exchange_rate;
+-- +goose StatementEnd
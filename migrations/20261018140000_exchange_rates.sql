@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+create table exchange_rates (
+    from_currency text not null,
+    to_currency text not null,
+    rate numeric not null,
+    source text not null,
+    updated_at timestamp,
+    expires_at timestamp not null,
+    primary key (from_currency, to_currency)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table exchange_rates;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Balances and log records of ledger are stored in minor units of its base currency
+create table ledgers (
+    chat_id bigint primary key,
+    base_currency text not null,
+    base_decimals int not null
+);
+
+-- Everything before was stored in USD cents
+insert into ledgers (chat_id, base_currency, base_decimals)
+    select distinct chat_id, 'USD', 2 from chat_members;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table ledgers;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Personal preferences of user, shared between all chats
+create table user_settings (
+    user_id int primary key,
+    display_currency text not null default ''
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table user_settings;
+-- +goose StatementEnd
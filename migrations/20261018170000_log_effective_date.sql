@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Date when expense actually happened, it's empty if it's the date of record
+alter table transactionlog add column effective_date date;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table transactionlog drop column effective_date;
+-- +goose StatementEnd
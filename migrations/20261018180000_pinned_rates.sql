@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Rates set by chat members, e.g. rate of exchange kiosk on a trip. Rate is amount of currency for one unit
+-- of ledger base currency, so it's ignored after ledger is rebased to another currency.
+create table pinned_rates (
+    chat_id bigint not null,
+    currency text not null,
+    base_currency text not null,
+    rate numeric not null,
+    author_id int not null,
+    created_at timestamptz not null default now(),
+    expires_at timestamptz,
+    primary key (chat_id, currency)
+);
+
+alter table transactionlog add column rate_source text;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table pinned_rates;
+
+alter table transactionlog drop column rate_source;
+-- +goose StatementEnd
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Amounts were integers in minor units of ledger base currency, now they are exact decimals in base currency
+alter table accounts alter column balance type numeric using balance::numeric;
+update accounts a
+    set balance = round(a.balance / power(10::numeric, l.base_decimals), l.base_decimals)
+    from ledgers l
+    where l.chat_id = a.chat_id;
+
+alter table transactionlog alter column balance_change type numeric using balance_change::numeric;
+update transactionlog t
+    set balance_change = round(t.balance_change / power(10::numeric, l.base_decimals), l.base_decimals)
+    from ledgers l
+    where l.chat_id = t.chat_id;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+update accounts a
+    set balance = round(a.balance * power(10::numeric, l.base_decimals))
+    from ledgers l
+    where l.chat_id = a.chat_id;
+alter table accounts alter column balance type int using round(balance)::int;
+
+update transactionlog t
+    set balance_change = round(t.balance_change * power(10::numeric, l.base_decimals))
+    from ledgers l
+    where l.chat_id = t.chat_id;
+alter table transactionlog alter column balance_change type bigint using round(balance_change)::bigint;
+-- +goose StatementEnd
@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Debts to people who have not run /register yet. They are written to transactionlog with the same operation
+-- when user with to_username registers in the chat, until then they form pending accounts of the author.
+create table pending_transfers (
+    id serial primary key,
+    chat_id bigint not null,
+    operation_id bigint not null,
+    author_id int not null,
+    from_user int not null references users(id),
+    to_username text not null,
+    kind text not null,
+    amount numeric not null,
+    original_amount numeric,
+    currency text,
+    exchange_rate numeric,
+    rate_source text,
+    effective_date date,
+    comment text not null default '',
+    created_at timestamptz not null default now()
+);
+create index pending_transfers_username_idx on pending_transfers (chat_id, lower(to_username));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table pending_transfers;
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Members who are not on Telegram get negative IDs, so they never collide with Telegram IDs
+create sequence virtual_users_id_seq increment by -1 maxvalue -1 start with -1;
+
+alter table users add column is_virtual bool not null default false;
+-- Virtual member linked to Telegram account keeps its name for old log records about debts with that account
+alter table users add column linked_to int references users(id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table users drop column linked_to;
+alter table users drop column is_virtual;
+
+drop sequence virtual_users_id_seq;
+-- +goose StatementEnd
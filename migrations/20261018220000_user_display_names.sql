@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- First and last name from Telegram, they are shown for users without username
+alter table users add column display_name text not null default '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table users drop column display_name;
+-- +goose StatementEnd
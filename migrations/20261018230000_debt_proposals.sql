@@ -0,0 +1,46 @@
+-- +goose Up
+-- +goose StatementBegin
+-- In chats with confirm_debts new debts are kept as proposals until every debtor confirms them
+alter table ledgers add column confirm_debts bool not null default false;
+
+-- Proposal keeps operation of /debt, it's written to transactionlog as operation_id when it's accepted
+create table debt_proposals (
+    id serial primary key,
+    chat_id bigint not null,
+    author_id int not null references users(id),
+    message_id int not null default 0,
+    description text not null default '',
+    comment text not null default '',
+    currency text,
+    exchange_rate numeric,
+    rate_source text,
+    effective_date date,
+    status text not null default 'open',
+    operation_id bigint,
+    created_at timestamptz not null default now(),
+    expires_at timestamptz not null
+);
+create index debt_proposals_open_idx on debt_proposals (expires_at) where status = 'open';
+
+-- Transfers to users who are not registered or not on Telegram don't need confirmation, to_user is null
+-- for users who are not registered and to_username is used instead like in pending_transfers
+create table debt_proposal_transfers (
+    proposal_id int not null references debt_proposals(id) on delete cascade,
+    from_user int not null references users(id),
+    to_user int references users(id),
+    to_username text not null default '',
+    amount numeric not null,
+    original_amount numeric not null,
+    needs_confirmation bool not null,
+    confirmed bool not null default false
+);
+create index debt_proposal_transfers_proposal_id_idx on debt_proposal_transfers (proposal_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table debt_proposal_transfers;
+drop table debt_proposals;
+
+alter table ledgers drop column confirm_debts;
+-- +goose StatementEnd
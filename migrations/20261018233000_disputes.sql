@@ -0,0 +1,31 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Dispute is an objection to operation from one of its parties, it's open until resolved_at is set
+create table disputes (
+    id serial primary key,
+    chat_id bigint not null,
+    operation_id bigint not null,
+    opened_by int not null references users(id),
+    resolved_by int references users(id),
+    resolution text,
+    created_at timestamptz not null default now(),
+    resolved_at timestamptz
+);
+-- Operation can have only one open dispute, later objections are added to its thread
+create unique index disputes_open_idx on disputes (chat_id, operation_id) where resolved_at is null;
+
+create table dispute_comments (
+    id serial primary key,
+    dispute_id int not null references disputes(id) on delete cascade,
+    author_id int not null references users(id),
+    text text not null,
+    created_at timestamptz not null default now()
+);
+create index dispute_comments_dispute_id_idx on dispute_comments (dispute_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table dispute_comments;
+drop table disputes;
+-- +goose StatementEnd
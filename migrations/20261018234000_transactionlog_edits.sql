@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Previous versions of edited operations. Every edit copies records and pending transfers of operation here
+-- before they are replaced, pending transfers have to_username instead of to_user.
+create table transactionlog_edits (
+    id serial primary key,
+    chat_id bigint not null,
+    operation_id bigint not null,
+    version int not null,
+    edited_by int not null references users(id),
+    edited_at timestamptz not null default now(),
+    author_id int not null,
+    from_user int not null,
+    to_user int,
+    to_username text,
+    kind text,
+    balance_change numeric not null,
+    original_amount numeric,
+    currency text,
+    exchange_rate numeric,
+    rate_source text,
+    comment text,
+    effective_date date,
+    ts timestamp
+);
+create index transactionlog_edits_operation_id_idx on transactionlog_edits (chat_id, operation_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table transactionlog_edits;
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Message with command operation was written by, edits of the message are applied to the operation
+-- and reply "undo" to it reverts the operation
+alter table transactionlog add column message_id int;
+create index transactionlog_message_id_idx on transactionlog (chat_id, message_id) where message_id is not null;
+
+alter table pending_transfers add column message_id int;
+alter table transactionlog_edits add column message_id int;
+alter table debt_proposals add column source_message_id int;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table debt_proposals drop column source_message_id;
+alter table transactionlog_edits drop column message_id;
+alter table pending_transfers drop column message_id;
+
+drop index transactionlog_message_id_idx;
+alter table transactionlog drop column message_id;
+-- +goose StatementEnd
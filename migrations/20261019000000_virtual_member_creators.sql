@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Virtual member can be linked by user who added it, members added before are linked by chat admins only
+alter table users add column created_by int references users(id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table users drop column created_by;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Replies of bot about operations, reply "undo" to them reverts the operation like reply to the command itself
+create table operation_messages (
+    chat_id bigint not null,
+    message_id int not null,
+    operation_id bigint not null,
+    primary key (chat_id, message_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table operation_messages;
+-- +goose StatementEnd
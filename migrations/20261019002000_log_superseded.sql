@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Edit of operation doesn't delete its records, they are compensated by correction records and both are
+-- marked superseded. Superseded records keep balances consistent with the log but are not shown.
+alter table transactionlog
+    add column superseded boolean not null default false;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table transactionlog
+    drop column superseded;
+-- +goose StatementEnd
@@ -0,0 +1,105 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+var errBadAmount = errors.New("bad amount")
+
+// thousandSuffixes multiply amount by 1000, e.g. 1.5k
+var thousandSuffixes = []string{"k", "K", "к", "К"}
+
+// parseNumber parses number written as people do in different locales: "12.50", "12,50", "1 200", "1.200,50",
+// "1,200.50" and "1.5k". Single separator is decimal one, repeated separator or one followed by another
+// separator groups thousands, so "1,200" is 1.2 and "1,200,000" is a million. Number is exact, it's rounded
+// to minor units only when currency is known.
+func parseNumber(s string) (*big.Rat, error) {
+	multiplier := big.NewRat(1, 1)
+	for _, suffix := range thousandSuffixes {
+		if strings.HasSuffix(s, suffix) {
+			s = strings.TrimSuffix(s, suffix)
+			multiplier = big.NewRat(1000, 1)
+			break
+		}
+	}
+	// Telegram clients can put non-breaking spaces between groups
+	s = strings.NewReplacer("\u00a0", " ", "\u202f", " ").Replace(s)
+
+	lastDot, lastComma := strings.LastIndex(s, "."), strings.LastIndex(s, ",")
+	var decimalSep string
+	switch {
+	case lastDot >= 0 && lastComma >= 0:
+		decimalSep = ","
+		if lastDot > lastComma {
+			decimalSep = "."
+		}
+	case lastDot >= 0 && strings.Count(s, ".") == 1:
+		decimalSep = "."
+	case lastComma >= 0 && strings.Count(s, ",") == 1:
+		decimalSep = ","
+	}
+
+	integer, fraction := s, ""
+	if decimalSep != "" {
+		i := strings.LastIndex(s, decimalSep)
+		integer, fraction = s[:i], s[i+1:]
+		if fraction == "" || !isDigits(fraction) {
+			return nil, fmt.Errorf("%w: %q has bad fraction", errBadAmount, s)
+		}
+	}
+
+	digits := "0"
+	if integer != "" || fraction == "" {
+		var err error
+		if digits, err = joinThousands(integer); err != nil {
+			return nil, fmt.Errorf("%w: %q %v", errBadAmount, s, err)
+		}
+	}
+
+	if fraction != "" {
+		digits += "." + fraction
+	}
+	value, ok := new(big.Rat).SetString(digits)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", errBadAmount, s)
+	}
+	return value.Mul(value, multiplier), nil
+}
+
+// joinThousands removes separators of thousand groups from integer part of number, all separators must be the same
+// and groups after the first one must have 3 digits
+func joinThousands(integer string) (string, error) {
+	sepIndex := strings.IndexAny(integer, " .,")
+	if sepIndex < 0 {
+		if !isDigits(integer) {
+			return "", fmt.Errorf("has not digits")
+		}
+		return integer, nil
+	}
+
+	groups := strings.Split(integer, integer[sepIndex:sepIndex+1])
+	for i, group := range groups {
+		if !isDigits(group) {
+			return "", fmt.Errorf("has bad group %q", group)
+		}
+		if (i == 0 && len(group) > 3) || (i > 0 && len(group) != 3) {
+			return "", fmt.Errorf("has group %q of wrong length", group)
+		}
+	}
+	return strings.Join(groups, ""), nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -3,7 +3,9 @@ package core
 import (
 	"context"
 	"fmt"
+	"html"
 	"moneyjar/pkg/database"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 	tg "gopkg.in/telebot.v3"
@@ -13,33 +15,72 @@ func (c Core) balanceCommand(tgCtx tg.Context) error {
 	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
 	defer cancel()
 
+	chatID := tgCtx.Chat().ID
 	userID := int(tgCtx.Sender().ID)
 
-	accounts, err := c.db.GetAccountsWithUser(ctx, userID)
+	base, err := c.ledgerCurrency(ctx, chatID)
+	if err != nil {
+		log.Errorf("failed to get ledger currency: %v", err)
+		msg := c.messages["failedToGetLedger"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	accounts, err := c.db.GetAccountsWithUser(ctx, chatID, userID)
 	if err != nil {
 		log.Errorf("failed to get accounts: %v", err)
 		msg := c.messages["failedToGetAccounts"]
 		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
 	}
 
-	msg := generateBalanceMessage(accounts)
+	pending, err := c.db.GetPendingAccounts(ctx, chatID, userID)
+	if err != nil {
+		log.Errorf("failed to get pending accounts: %v", err)
+		msg := c.messages["failedToGetAccounts"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+	accounts = append(accounts, pending...)
+
+	msg := generateBalanceMessage(accounts, c.userDisplay(ctx, userID, base))
 
 	return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message(), ParseMode: tg.ModeHTML, DisableNotification: true})
 }
 
-func generateBalanceMessage(balances []database.Account) (msg string) {
-	const rowTemplate = "%d) <b>@%s</b> должен_а <b>@%s</b> %.2f$\n"
+// pendingMarker follows accounts of users who have not registered yet
+const pendingMarker = " ⏳"
+
+func generateBalanceMessage(balances []database.Account, d display) (msg string) {
+	const rowTemplate = "%d) <b>@%s</b> должен_а <b>@%s</b> %s%s\n"
 
 	for i, account := range balances {
-		balance := float64(account.Balance) / 100
+		var marker string
+		if account.IsPending {
+			marker = pendingMarker
+		}
+
+		// Display names of users without username can contain anything
+		from, to := html.EscapeString(account.FromUserName), html.EscapeString(account.ToUserName)
 
 		var row string
-		if balance >= 0 {
-			row = fmt.Sprintf(rowTemplate, i+1, account.ToUserName, account.FromUserName, balance)
+		if account.Balance.Sign() >= 0 {
+			row = fmt.Sprintf(rowTemplate, i+1, to, from, d.format(account.Balance), marker)
 		} else {
-			row = fmt.Sprintf(rowTemplate, i+1, account.FromUserName, account.ToUserName, balance*-1)
+			row = fmt.Sprintf(rowTemplate, i+1, from, to, d.format(account.Balance.Neg()), marker)
 		}
 		msg += row
 	}
 	return msg
 }
+
+// pendingUsersNote explains that debts of users who have not registered yet are kept until they do
+func (c Core) pendingUsersNote(accounts []database.Account) string {
+	var names []string
+	for _, account := range accounts {
+		if account.IsPending {
+			names = append(names, "@"+html.EscapeString(account.ToUserName))
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	return "\n" + fmt.Sprintf(c.messages["pendingUsers"], strings.Join(names, ", "))
+}
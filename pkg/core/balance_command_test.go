@@ -0,0 +1,61 @@
+package core
+
+import (
+	"moneyjar/pkg/currency"
+	"moneyjar/pkg/database"
+	"moneyjar/pkg/money"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_generateBalanceMessage(t *testing.T) {
+	usd := currency.Currency{Code: "USD", Symbols: []string{"$"}, Decimals: 2}
+	rub := currency.Currency{Code: "RUB", Symbols: []string{"₽"}, Decimals: 2}
+	jpy := currency.Currency{Code: "JPY", Decimals: 0}
+
+	accounts := []database.Account{
+		{FromUserName: "a", ToUserName: "b", Balance: money.New(1050, 2)},
+		{FromUserName: "a", ToUserName: "c", Balance: money.New(-200, 2)},
+	}
+
+	tests := []struct {
+		name string
+		d    display
+		want string
+	}{
+		{
+			name: "base currency",
+			d:    display{base: usd, cur: usd, rate: 1},
+			want: "1) <b>@b</b> должен_а <b>@a</b> 10.50$\n" +
+				"2) <b>@a</b> должен_а <b>@c</b> 2.00$\n",
+		},
+		{
+			name: "display currency without decimals",
+			d:    display{base: usd, cur: jpy, rate: 150.5},
+			want: "1) <b>@b</b> должен_а <b>@a</b> ≈1580 JPY\n" +
+				"2) <b>@a</b> должен_а <b>@c</b> ≈301 JPY\n",
+		},
+		{
+			name: "display currency",
+			d:    display{base: usd, cur: rub, rate: 90},
+			want: "1) <b>@b</b> должен_а <b>@a</b> ≈945.00₽\n" +
+				"2) <b>@a</b> должен_а <b>@c</b> ≈180.00₽\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, generateBalanceMessage(accounts, tt.d))
+		})
+	}
+}
+
+func Test_generateBalanceMessage_pending(t *testing.T) {
+	usd := currency.Currency{Code: "USD", Symbols: []string{"$"}, Decimals: 2}
+	accounts := []database.Account{
+		{FromUserName: "a", ToUserName: "newbie", Balance: money.New(500, 2), IsPending: true},
+	}
+
+	got := generateBalanceMessage(accounts, display{base: usd, cur: usd, rate: 1})
+	assert.Equal(t, "1) <b>@newbie</b> должен_а <b>@a</b> 5.00$ ⏳\n", got)
+}
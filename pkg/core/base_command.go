@@ -0,0 +1,105 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"moneyjar/pkg/database"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	tg "gopkg.in/telebot.v3"
+)
+
+var reBasePayload = regexp.MustCompile(`^(\S+)(?: +([\d.,]+))?$`)
+
+func (c Core) baseCommand(tgCtx tg.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	chatID := tgCtx.Chat().ID
+
+	base, err := c.ledgerCurrency(ctx, chatID)
+	if err != nil {
+		log.Errorf("failed to get ledger currency: %v", err)
+		msg := c.messages["failedToGetLedger"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	payload := strings.TrimSpace(tgCtx.Message().Payload)
+	if payload == "" {
+		msg := fmt.Sprintf(c.messages["ledgerBaseCurrency"], base.Code)
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	match := reBasePayload.FindStringSubmatch(payload)
+	if len(match) < 3 {
+		msg := c.messages["failedToParsePayload"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	// Rebase converts balances of everyone in chat at the rate from payload, so it's up to chat admins
+	isAdmin, err := c.isChatAdmin(tgCtx)
+	if err != nil {
+		log.Errorf("failed to check if user %d is admin: %v", tgCtx.Sender().ID, err)
+		msg := c.messages["failedToRebaseLedger"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+	if !isAdmin {
+		msg := c.messages["onlyAdminCanRebase"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	cur, err := c.parseCurrency(match[1])
+	if err != nil {
+		msg := fmt.Sprintf(c.messages["unknownCurrency"], strings.Join(c.currencies.Codes(), ", "))
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+	if cur.Code == base.Code && cur.Decimals == base.Decimals {
+		msg := fmt.Sprintf(c.messages["ledgerBaseCurrency"], base.Code)
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	// Rate is amount of new currency for one unit of the current one, market rate is used if it's not set
+	var rate float64 = 1
+	switch {
+	case match[2] != "":
+		rate, err = strconv.ParseFloat(strings.ReplaceAll(match[2], ",", "."), 64)
+		if err != nil || rate <= 0 {
+			log.Errorf("failed to parse rate %q: %v", match[2], err)
+			msg := c.messages["failedToParsePayload"]
+			return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+		}
+	case cur.Code != base.Code:
+		quote, err := c.rates.Rate(ctx, base.Code, cur.Code)
+		if err != nil {
+			log.Errorf("failed to get rate %s/%s: %v", base.Code, cur.Code, err)
+			msg := c.messages["failedToConvertCurrency"]
+			return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+		}
+		rate = quote.Rate
+	}
+
+	// Chats without registered users have no ledger yet
+	current := database.Ledger{ChatID: chatID, BaseCurrency: base.Code, BaseDecimals: base.Decimals}
+	if err = c.db.CreateLedger(ctx, current); err != nil {
+		log.Errorf("failed to create ledger: %v", err)
+		msg := c.messages["failedToGetLedger"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	ledger := database.Ledger{ChatID: chatID, BaseCurrency: cur.Code, BaseDecimals: cur.Decimals}
+	if err = c.db.RebaseLedger(ctx, ledger, rate); err != nil {
+		log.Errorf("failed to rebase ledger: %v", err)
+		msg := c.messages["failedToRebaseLedger"]
+		if errors.Is(err, database.ErrLedgerNotFound) {
+			msg = c.messages["failedToGetLedger"]
+		}
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	msg := fmt.Sprintf(c.messages["ledgerRebased"], base.Code, cur.Code, rate)
+	return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+}
@@ -0,0 +1,335 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"moneyjar/pkg/currency"
+	"moneyjar/pkg/database"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	tg "gopkg.in/telebot.v3"
+)
+
+const (
+	confirmOnArg  = "on"
+	confirmOffArg = "off"
+
+	// proposalExpiryInterval is how often proposals which are out of time are closed
+	proposalExpiryInterval = time.Minute
+)
+
+var (
+	acceptDebtButton  = tg.InlineButton{Unique: "accept_debt", Text: "✅ Подтвердить"}
+	declineDebtButton = tg.InlineButton{Unique: "decline_debt", Text: "❌ Отклонить"}
+)
+
+func (c Core) confirmCommand(tgCtx tg.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	chatID := tgCtx.Chat().ID
+	payload := strings.TrimSpace(tgCtx.Message().Payload)
+
+	if payload == "" {
+		confirm, err := c.confirmDebts(ctx, chatID)
+		if err != nil {
+			log.Errorf("failed to get ledger: %v", err)
+			msg := c.messages["failedToGetLedger"]
+			return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+		}
+		msg := c.messages["confirmDebtsOff"]
+		if confirm {
+			msg = c.messages["confirmDebtsOn"]
+		}
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	if payload != confirmOnArg && payload != confirmOffArg {
+		msg := c.messages["failedToParsePayload"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	// Confirmation protects debtors, so members can't turn it off for themselves
+	isAdmin, err := c.isChatAdmin(tgCtx)
+	if err != nil {
+		log.Errorf("failed to check if user %d is admin: %v", tgCtx.Sender().ID, err)
+		msg := c.messages["failedToGetLedger"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+	if !isAdmin {
+		msg := c.messages["onlyAdminCanSetConfirm"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	base, err := c.ledgerCurrency(ctx, chatID)
+	if err != nil {
+		log.Errorf("failed to get ledger currency: %v", err)
+		msg := c.messages["failedToGetLedger"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	// Chats without registered users have no ledger yet
+	ledger := database.Ledger{ChatID: chatID, BaseCurrency: base.Code, BaseDecimals: base.Decimals}
+	if err = c.db.CreateLedger(ctx, ledger); err != nil {
+		log.Errorf("failed to create ledger: %v", err)
+		msg := c.messages["failedToGetLedger"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	confirm := payload == confirmOnArg
+	if err = c.db.SetConfirmDebts(ctx, chatID, confirm); err != nil {
+		log.Errorf("failed to set confirmation of debts: %v", err)
+		msg := c.messages["failedToGetLedger"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	msg := c.messages["confirmDebtsOff"]
+	if confirm {
+		msg = c.messages["confirmDebtsOn"]
+	}
+	return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+}
+
+// confirmDebts tells whether new debts in chat wait for confirmation of debtors
+func (c Core) confirmDebts(ctx context.Context, chatID int64) (bool, error) {
+	ledger, err := c.db.GetLedger(ctx, chatID)
+	if errors.Is(err, database.ErrLedgerNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return ledger.ConfirmDebts, nil
+}
+
+// needsConfirmation tells whether any of debtors can confirm the debt. Pending and virtual users
+// can't press buttons and creditors have nothing to confirm, so such debts are written right away.
+func needsConfirmation(transfers []database.Transfer) bool {
+	for _, transfer := range transfers {
+		if transfer.NeedsConfirmation() {
+			return true
+		}
+	}
+	return false
+}
+
+// proposeDebt keeps debt as proposal and sends message with confirmation buttons to debtors
+func (c Core) proposeDebt(
+	ctx context.Context, tgCtx tg.Context, op database.Operation, transfers []database.Transfer, description string,
+) error {
+	base, err := c.ledgerCurrency(ctx, op.ChatID)
+	if err != nil {
+		log.Errorf("failed to get ledger currency: %v", err)
+		msg := c.messages["failedToGetLedger"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	proposal := database.Proposal{Description: description, ExpiresAt: time.Now().Add(c.proposalTTL)}
+	if err = c.db.CreateProposal(ctx, &proposal, op, transfers); err != nil {
+		log.Errorf("failed to create debt proposal: %v", err)
+		msg := c.messages["failedToProposeDebt"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	sent, err := c.tg.Send(tgCtx.Recipient(), c.proposalMessage(proposal, base), &tg.SendOptions{
+		ReplyTo:     tgCtx.Message(),
+		ParseMode:   tg.ModeHTML,
+		ReplyMarkup: proposalMarkup(proposal.ID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send debt proposal %d: %v", proposal.ID, err)
+	}
+	if err = c.db.SetProposalMessage(ctx, proposal.ID, sent.ID); err != nil {
+		// Buttons still work, only message of expired proposal is not updated
+		log.Errorf("failed to save message of debt proposal: %v", err)
+	}
+	return nil
+}
+
+func (c Core) acceptDebtCallback(tgCtx tg.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	proposalID, err := strconv.ParseInt(tgCtx.Data(), 10, 64)
+	if err != nil {
+		log.Errorf("failed to parse debt proposal id %q: %v", tgCtx.Data(), err)
+		return tgCtx.Respond(&tg.CallbackResponse{Text: c.messages["proposalNotFound"]})
+	}
+
+	chatID := tgCtx.Chat().ID
+	proposal, accounts, err := c.db.ConfirmProposal(ctx, chatID, proposalID, int(tgCtx.Sender().ID))
+	switch {
+	case errors.Is(err, database.ErrProposalClosed):
+		return c.answerClosedProposal(ctx, tgCtx, proposal)
+	case errors.Is(err, database.ErrNotDebtor):
+		return tgCtx.Respond(&tg.CallbackResponse{Text: c.messages["notDebtor"]})
+	case errors.Is(err, database.ErrProposalNotFound):
+		return tgCtx.Respond(&tg.CallbackResponse{Text: c.messages["proposalNotFound"]})
+	case err != nil:
+		log.Errorf("failed to confirm debt proposal: %v", err)
+		return tgCtx.Respond(&tg.CallbackResponse{Text: c.messages["failedToUpdateBalance"]})
+	}
+
+	base, err := c.ledgerCurrency(ctx, chatID)
+	if err != nil {
+		log.Errorf("failed to get ledger currency: %v", err)
+		return tgCtx.Respond(&tg.CallbackResponse{Text: c.messages["failedToGetLedger"]})
+	}
+
+	if err = tgCtx.Respond(&tg.CallbackResponse{Text: c.messages["proposalConfirmed"]}); err != nil {
+		log.Errorf("failed to answer callback: %v", err)
+	}
+
+	msg := c.proposalMessage(proposal, base)
+	if proposal.Status == database.ProposalAccepted {
+		msg += generateBalanceMessage(accounts, c.userDisplay(ctx, proposal.AuthorID, base))
+		msg += c.pendingUsersNote(accounts)
+	}
+	return c.editProposalMessage(tgCtx, proposal, msg)
+}
+
+func (c Core) declineDebtCallback(tgCtx tg.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	proposalID, err := strconv.ParseInt(tgCtx.Data(), 10, 64)
+	if err != nil {
+		log.Errorf("failed to parse debt proposal id %q: %v", tgCtx.Data(), err)
+		return tgCtx.Respond(&tg.CallbackResponse{Text: c.messages["proposalNotFound"]})
+	}
+
+	chatID := tgCtx.Chat().ID
+	proposal, err := c.db.DeclineProposal(ctx, chatID, proposalID, int(tgCtx.Sender().ID))
+	switch {
+	case errors.Is(err, database.ErrProposalClosed):
+		return c.answerClosedProposal(ctx, tgCtx, proposal)
+	case errors.Is(err, database.ErrNotDebtor):
+		return tgCtx.Respond(&tg.CallbackResponse{Text: c.messages["notDebtor"]})
+	case errors.Is(err, database.ErrProposalNotFound):
+		return tgCtx.Respond(&tg.CallbackResponse{Text: c.messages["proposalNotFound"]})
+	case err != nil:
+		log.Errorf("failed to decline debt proposal: %v", err)
+		return tgCtx.Respond(&tg.CallbackResponse{Text: c.messages["failedToDeclineDebt"]})
+	}
+
+	base, err := c.ledgerCurrency(ctx, chatID)
+	if err != nil {
+		log.Errorf("failed to get ledger currency: %v", err)
+		return tgCtx.Respond(&tg.CallbackResponse{Text: c.messages["failedToGetLedger"]})
+	}
+
+	if err = tgCtx.Respond(&tg.CallbackResponse{Text: c.messages["proposalDeclinedByYou"]}); err != nil {
+		log.Errorf("failed to answer callback: %v", err)
+	}
+
+	msg := c.proposalMessage(proposal, base)
+	msg += fmt.Sprintf(c.messages["proposalDeclinedBy"], html.EscapeString(senderName(tgCtx.Sender())))
+	return c.editProposalMessage(tgCtx, proposal, msg)
+}
+
+// answerClosedProposal tells that proposal can't be answered anymore and removes its buttons,
+// message could still have them if proposal expired between checks
+func (c Core) answerClosedProposal(ctx context.Context, tgCtx tg.Context, proposal database.Proposal) error {
+	if err := tgCtx.Respond(&tg.CallbackResponse{Text: c.messages["proposalClosed"]}); err != nil {
+		log.Errorf("failed to answer callback: %v", err)
+	}
+	if proposal.Status != database.ProposalExpired {
+		return nil
+	}
+
+	base, err := c.ledgerCurrency(ctx, proposal.ChatID)
+	if err != nil {
+		return fmt.Errorf("failed to get ledger currency: %v", err)
+	}
+	return c.editProposalMessage(tgCtx, proposal, c.proposalMessage(proposal, base))
+}
+
+// editProposalMessage replaces text of message with proposal, buttons are kept only while proposal is open
+func (c Core) editProposalMessage(tgCtx tg.Context, proposal database.Proposal, msg string) error {
+	opts := &tg.SendOptions{ParseMode: tg.ModeHTML}
+	if proposal.Status == database.ProposalOpen {
+		opts.ReplyMarkup = proposalMarkup(proposal.ID)
+	}
+
+	err := tgCtx.Edit(msg, opts)
+	if errors.Is(err, tg.ErrMessageNotModified) || errors.Is(err, tg.ErrSameMessageContent) {
+		return nil
+	}
+	return err
+}
+
+// expireProposals closes proposals which were not confirmed in time until bot stops
+func (c Core) expireProposals() {
+	ticker := time.NewTicker(proposalExpiryInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+		proposals, err := c.db.ExpireProposals(ctx)
+		if err != nil {
+			log.Errorf("failed to expire debt proposals: %v", err)
+		}
+
+		for _, proposal := range proposals {
+			if proposal.MessageID == 0 {
+				continue
+			}
+			base, curErr := c.ledgerCurrency(ctx, proposal.ChatID)
+			if curErr != nil {
+				log.Errorf("failed to get ledger currency: %v", curErr)
+				continue
+			}
+
+			msg := tg.StoredMessage{MessageID: strconv.Itoa(proposal.MessageID), ChatID: proposal.ChatID}
+			_, err = c.tg.Edit(msg, c.proposalMessage(proposal, base), &tg.SendOptions{ParseMode: tg.ModeHTML})
+			if err != nil {
+				log.Errorf("failed to update message of expired debt proposal %d: %v", proposal.ID, err)
+			}
+		}
+		cancel()
+	}
+}
+
+// proposalMarkup returns confirmation buttons of proposal
+func proposalMarkup(proposalID int64) *tg.ReplyMarkup {
+	data := strconv.FormatInt(proposalID, 10)
+	return &tg.ReplyMarkup{InlineKeyboard: [][]tg.InlineButton{{
+		*acceptDebtButton.With(data),
+		*declineDebtButton.With(data),
+	}}}
+}
+
+// proposalMessage describes state of proposal and shares of debtors, shares of accepted proposal
+// are not listed because they are shown as updated balances
+func (c Core) proposalMessage(proposal database.Proposal, base currency.Currency) string {
+	const rowTemplate = "%s <b>@%s</b> %s\n"
+
+	description := html.EscapeString(proposal.Description)
+
+	var msg string
+	switch proposal.Status {
+	case database.ProposalOpen:
+		expiresAt := proposal.ExpiresAt.Local().Format(timeLayout)
+		msg = fmt.Sprintf(c.messages["proposalOpen"], description, expiresAt)
+	case database.ProposalAccepted:
+		return fmt.Sprintf(c.messages["proposalAccepted"], proposal.OperationID.Int64, description)
+	case database.ProposalDeclined:
+		msg = fmt.Sprintf(c.messages["proposalDeclined"], description)
+	case database.ProposalExpired:
+		msg = fmt.Sprintf(c.messages["proposalExpired"], description)
+	}
+
+	for _, debtor := range proposal.Debtors {
+		mark := "⏳"
+		if debtor.Confirmed {
+			mark = "✅"
+		}
+		msg += fmt.Sprintf(rowTemplate, mark, html.EscapeString(debtor.Name), base.Format(debtor.Amount))
+	}
+	return msg
+}
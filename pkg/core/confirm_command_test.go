@@ -0,0 +1,98 @@
+package core
+
+import (
+	"database/sql"
+	"moneyjar/pkg/currency"
+	"moneyjar/pkg/database"
+	"moneyjar/pkg/money"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_needsConfirmation(t *testing.T) {
+	tests := []struct {
+		name      string
+		transfers []database.Transfer
+		want      bool
+	}{
+		{
+			name:      "registered debtor",
+			transfers: []database.Transfer{{Account: database.Account{FromUser: 1, ToUser: 2}, Amount: money.New(500, 2)}},
+			want:      true,
+		},
+		{
+			name: "pending and virtual debtors",
+			transfers: []database.Transfer{
+				{Account: database.Account{FromUser: 1, ToUserName: "newbie", IsPending: true}, Amount: money.New(500, 2)},
+				{Account: database.Account{FromUser: 1, ToUser: -1}, Amount: money.New(500, 2)},
+			},
+			want: false,
+		},
+		{
+			name: "mixed debtors",
+			transfers: []database.Transfer{
+				{Account: database.Account{FromUser: 1, ToUser: -1}, Amount: money.New(500, 2)},
+				{Account: database.Account{FromUser: 1, ToUser: 2}, Amount: money.New(500, 2)},
+			},
+			want: true,
+		},
+		{
+			name:      "registered creditor",
+			transfers: []database.Transfer{{Account: database.Account{FromUser: 1, ToUser: 2}, Amount: money.New(-500, 2)}},
+			want:      false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, needsConfirmation(tt.transfers))
+		})
+	}
+}
+
+func Test_proposalMessage(t *testing.T) {
+	c := Core{messages: map[string]string{
+		"proposalOpen":     "open %s until %s:\n",
+		"proposalAccepted": "accepted #%d %s\n",
+		"proposalExpired":  "expired %s:\n",
+	}}
+	usd := currency.Currency{Code: "USD", Symbols: []string{"$"}, Decimals: 2}
+	expiresAt := time.Date(2026, 10, 19, 15, 4, 0, 0, time.Local)
+	debtors := []database.Debtor{
+		{UserID: 2, Name: "b", Amount: money.New(500, 2), Confirmed: true},
+		{UserID: 3, Name: "c<d>", Amount: money.New(250, 2)},
+	}
+
+	tests := []struct {
+		name     string
+		proposal database.Proposal
+		want     string
+	}{
+		{
+			name:     "open",
+			proposal: database.Proposal{Description: "10 $", Status: database.ProposalOpen, ExpiresAt: expiresAt, Debtors: debtors},
+			want:     "open 10 $ until 19.10.2026 15:04:\n✅ <b>@b</b> 5.00$\n⏳ <b>@c&lt;d&gt;</b> 2.50$\n",
+		},
+		{
+			name: "accepted",
+			proposal: database.Proposal{
+				Description: "10 $",
+				Status:      database.ProposalAccepted,
+				OperationID: sql.NullInt64{Int64: 42, Valid: true},
+				Debtors:     debtors,
+			},
+			want: "accepted #42 10 $\n",
+		},
+		{
+			name:     "expired",
+			proposal: database.Proposal{Description: "10 $", Status: database.ProposalExpired, Debtors: debtors[:1]},
+			want:     "expired 10 $:\n✅ <b>@b</b> 5.00$\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, c.proposalMessage(tt.proposal, usd))
+		})
+	}
+}
@@ -1,84 +1,301 @@
 package core
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"math"
+	"math/big"
+	"moneyjar/pkg/config"
+	"moneyjar/pkg/currency"
+	"moneyjar/pkg/database"
+	"moneyjar/pkg/money"
+	"moneyjar/pkg/rates"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
 )
 
-// ExchangeRateResponse represents models of API response
-type ExchangeRateResponse struct {
-	Result             string  `json:"result"`
-	Documentation      string  `json:"documentation"`
-	TermsOfUse         string  `json:"terms_of_use"`
-	TimeLastUpdateUnix int     `json:"time_last_update_unix"`
-	TimeLastUpdateUtc  string  `json:"time_last_update_utc"`
-	TimeNextUpdateUnix int     `json:"time_next_update_unix"`
-	TimeNextUpdateUtc  string  `json:"time_next_update_utc"`
-	BaseCode           string  `json:"base_code"`
-	TargetCode         string  `json:"target_code"`
-	ConversionRate     float64 `json:"conversion_rate"`
-	ConversionResult   float64 `json:"conversion_result"`
+var errUnknownCurrency = errors.New("unknown currency")
+
+// defaultBaseCurrency is used when ledger.base_currency is not set in config
+const defaultBaseCurrency = "USD"
+
+// timeLayout is used to show time to users
+const timeLayout = "02.01.2006 15:04"
+
+// dateLayout is used to show dates to users
+const dateLayout = "02.01.2006"
+
+// defaultRateProviders are used when rates.providers is not set in config
+var defaultRateProviders = []string{"api", "ecb", "static"}
+
+// newCurrencyRegistry loads currencies from config
+func newCurrencyRegistry() (*currency.Registry, error) {
+	var currencies []currency.Currency
+	if err := config.C.Unmarshal("currencies", &currencies); err != nil {
+		return nil, fmt.Errorf("failed to parse currencies: %v", err)
+	}
+	if len(currencies) == 0 {
+		currencies = currency.Defaults
+	}
+
+	return currency.NewRegistry(currencies)
 }
 
-type currency string
+// newRateProvider builds chain of upstream rate providers in order from config behind cache. Static rates
+// are used only if cache has no rate at all, otherwise last market rate is better than fixed one.
+func newRateProvider(defaultBase string, store rates.Store, cacheTTL time.Duration) (rates.Provider, *rates.Cache, error) {
+	httpClient := &http.Client{Timeout: apiTimeout}
 
-const (
-	usd currency = "usd"
-	rub currency = "rub"
-	gel currency = "gel"
-)
+	names := config.C.Strings("rates.providers")
+	if len(names) == 0 {
+		names = defaultRateProviders
+	}
 
-const apiEndpoint = "https://v6.exchangerate-api.com/v6/%s/pair/%s/%s/%f"
+	var (
+		upstream = make([]rates.Provider, 0, len(names))
+		static   *rates.Static
+	)
+	for _, name := range names {
+		switch name {
+		case "api":
+			upstream = append(upstream, rates.NewAPI(config.C.String("api_key"), httpClient))
+		case "ecb":
+			upstream = append(upstream, rates.NewECB(httpClient))
+		case "static":
+			staticRates := make(map[string]float64)
+			for cur, rate := range config.C.Float64Map("rates.static.rates") {
+				staticRates[strings.ToUpper(cur)] = rate
+			}
+			base := strings.ToUpper(config.C.String("rates.static.base"))
+			if base == "" {
+				base = defaultBase
+			}
+			static = rates.NewStatic(base, staticRates)
+		default:
+			return nil, nil, fmt.Errorf("unknown rate provider: %s", name)
+		}
+	}
 
-func parseCurrency(s string) currency {
-	switch s {
-	case "$", "USD", "usd", "долларов", "доллара":
-		return usd
-	case "₽", "RUB", "rub", "рублей", "рубля":
-		return rub
-	case "₾", "GEL", "gel", "лари", "лар":
-		return gel
-	default:
-		return ""
+	cache := rates.NewCache(rates.NewChain(upstream...), store, cacheTTL)
+	if static == nil {
+		return cache, cache, nil
 	}
+	return rates.NewChain(cache, static), cache, nil
+}
+
+// rateStore keeps cached rates in database
+type rateStore struct {
+	db database.Provider
 }
 
-func (c Core) convertToUSD(cur currency, floatAmount float64) (int, error) {
-	if cur == "" {
-		return 0, fmt.Errorf("failed to parse currency")
+// GetExchangeRates implements rates.Store
+func (s rateStore) GetExchangeRates(ctx context.Context) ([]rates.StoredRate, error) {
+	exchangeRates, err := s.db.GetExchangeRates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	storedRates := make([]rates.StoredRate, 0, len(exchangeRates))
+	for _, r := range exchangeRates {
+		storedRates = append(storedRates, rates.StoredRate{
+			From:      r.FromCurrency,
+			To:        r.ToCurrency,
+			Rate:      r.Rate,
+			Source:    r.Source,
+			UpdatedAt: r.UpdatedAt.Time,
+			ExpiresAt: r.ExpiresAt,
+		})
 	}
+	return storedRates, nil
+}
+
+// SaveExchangeRate implements rates.Store
+func (s rateStore) SaveExchangeRate(ctx context.Context, rate rates.StoredRate) error {
+	exchangeRate := database.ExchangeRate{
+		FromCurrency: rate.From,
+		ToCurrency:   rate.To,
+		Rate:         rate.Rate,
+		Source:       rate.Source,
+		ExpiresAt:    rate.ExpiresAt,
+	}
+	if !rate.UpdatedAt.IsZero() {
+		exchangeRate.UpdatedAt.Time, exchangeRate.UpdatedAt.Valid = rate.UpdatedAt, true
+	}
+	return s.db.SaveExchangeRate(ctx, exchangeRate)
+}
 
-	var amountWasFlipped bool
-	if floatAmount < 0 {
-		floatAmount *= -1
-		amountWasFlipped = true
+// convertToBase returns amount rounded to minor units of ledger base currency and exchange rate used for conversion.
+// Rate pinned in chat is preferred, otherwise amount is converted at rate of date if it's set and at current rate.
+func (c Core) convertToBase(
+	ctx context.Context, chatID int64, cur currency.Currency, amount money.Amount, base currency.Currency, date time.Time,
+) (money.Amount, rates.Quote, error) {
+	if cur.Code == "" {
+		return money.Amount{}, rates.Quote{}, fmt.Errorf("failed to parse currency")
 	}
-	if cur != usd {
-		url := fmt.Sprintf(apiEndpoint, c.apiKey, cur, "usd", floatAmount)
-		resp, err := c.httpClient.Get(url)
+
+	quote := rates.Quote{From: cur.Code, To: base.Code, Rate: 1}
+	if cur.Code != base.Code {
+		var err error
+		if pinned, ok := c.pinnedQuote(ctx, chatID, cur.Code, base.Code, date); ok {
+			quote = pinned
+		} else if date.IsZero() {
+			quote, err = c.rates.Rate(ctx, cur.Code, base.Code)
+		} else {
+			quote, err = c.rateAt(ctx, cur.Code, base.Code, date)
+		}
 		if err != nil {
-			return 0, err
+			return money.Amount{}, rates.Quote{}, err
+		}
+	}
+
+	baseAmount, err := amount.Mul(exactRate(quote.Rate), base.Decimals)
+	if err != nil {
+		return money.Amount{}, rates.Quote{}, err
+	}
+	return baseAmount, quote, nil
+}
+
+// exactRate turns rate to rational number as it's written, so 2.7 is 27/10 rather than closest binary fraction
+func exactRate(rate float64) *big.Rat {
+	r, ok := new(big.Rat).SetString(strconv.FormatFloat(rate, 'g', -1, 64))
+	if !ok {
+		return new(big.Rat).SetFloat64(rate)
+	}
+	return r
+}
+
+// pinnedQuote returns rate pinned in chat for conversion to ledger base currency. Pinned rate is not used
+// for expenses made before it was pinned, as it's not known what it was then.
+func (c Core) pinnedQuote(ctx context.Context, chatID int64, from, to string, date time.Time) (rates.Quote, bool) {
+	pinned, err := c.db.GetPinnedRates(ctx, chatID)
+	if err != nil {
+		log.Errorf("failed to get pinned rates, falling back to providers: %v", err)
+		return rates.Quote{}, false
+	}
+
+	for _, p := range pinned {
+		if p.Currency != from || p.BaseCurrency != to {
+			continue
 		}
-		if resp.StatusCode != http.StatusOK {
-			return 0, fmt.Errorf("api returned bad status: %v", resp.StatusCode)
+		pinnedDay := time.Date(p.CreatedAt.Year(), p.CreatedAt.Month(), p.CreatedAt.Day(), 0, 0, 0, 0, date.Location())
+		if !date.IsZero() && date.Before(pinnedDay) {
+			continue
 		}
-		defer resp.Body.Close()
+		return rates.Quote{
+			From:      from,
+			To:        to,
+			Rate:      1 / p.Rate,
+			Source:    pinnedRateSource,
+			UpdatedAt: p.CreatedAt,
+		}, true
+	}
+	return rates.Quote{}, false
+}
 
-		var exchangeRateResp ExchangeRateResponse
-		if err = json.NewDecoder(resp.Body).Decode(&exchangeRateResp); err != nil {
-			return 0, err
+// rateAt returns historical rate if rate provider has it, otherwise current rate is returned marked as fallback
+func (c Core) rateAt(ctx context.Context, from, to string, date time.Time) (rates.Quote, error) {
+	historical, ok := c.rates.(rates.HistoricalProvider)
+	if ok {
+		quote, err := historical.RateAt(ctx, from, to, date)
+		if err == nil {
+			return quote, nil
 		}
-		floatAmount = exchangeRateResp.ConversionResult
+		log.Warnf("failed to get rate %s/%s at %s, falling back to current rate: %v",
+			from, to, date.Format(dateLayout), err)
+	}
+
+	quote, err := c.rates.Rate(ctx, from, to)
+	if err != nil {
+		return rates.Quote{}, err
+	}
+	quote.Fallback = true
+	return quote, nil
+}
+
+// display shows amounts of ledger in currency chosen by user
+type display struct {
+	base currency.Currency
+	cur  currency.Currency
+	// rate is amount of cur for one unit of base
+	rate float64
+}
+
+// format shows amount of ledger base currency, converted amounts are marked as approximate
+func (d display) format(amount money.Amount) string {
+	if d.cur.Code == "" || d.cur.Code == d.base.Code {
+		return d.base.Format(amount)
 	}
+	converted, err := amount.Mul(exactRate(d.rate), d.cur.Decimals)
+	if err != nil {
+		// Amount which can't be converted is still shown in base currency
+		return d.base.Format(amount)
+	}
+	return "≈" + d.cur.Format(converted)
+}
 
-	var convertedFloatAmount float64
-	if amountWasFlipped {
-		convertedFloatAmount = floatAmount * -1
-	} else {
-		convertedFloatAmount = floatAmount
+// userDisplay returns display in currency chosen by user. Ledger base currency is used if user has not chosen
+// anything or rate is not available, as balances are still correct in it.
+func (c Core) userDisplay(ctx context.Context, userID int, base currency.Currency) display {
+	baseDisplay := display{base: base, cur: base, rate: 1}
+
+	settings, err := c.db.GetUserSettings(ctx, userID)
+	if err != nil {
+		log.Errorf("failed to get user settings: %v", err)
+		return baseDisplay
 	}
 
-	return int(math.Round(convertedFloatAmount * 100)), nil
+	cur, ok := c.currencies.Get(settings.DisplayCurrency)
+	if !ok || cur.Code == base.Code {
+		return baseDisplay
+	}
+
+	quote, err := c.rates.Rate(ctx, base.Code, cur.Code)
+	if err != nil {
+		log.Errorf("failed to get rate %s/%s for display: %v", base.Code, cur.Code, err)
+		return baseDisplay
+	}
+	return display{base: base, cur: cur, rate: quote.Rate}
+}
+
+// rateWarning returns warning for reply if quote was taken from outdated cache or it's not the rate of date
+func (c Core) rateWarning(quote rates.Quote) string {
+	var msg string
+	if quote.Fallback {
+		msg += "\n" + fmt.Sprintf(c.messages["historicalRateFallback"], quote.From)
+	}
+	if quote.Stale {
+		msg += "\n" + fmt.Sprintf(c.messages["staleExchangeRate"], quote.From, quote.UpdatedAt.Format(timeLayout))
+	}
+	return msg
+}
+
+// ledgerCurrency returns base currency of chat ledger, decimals are taken from ledger as balances are stored in them
+func (c Core) ledgerCurrency(ctx context.Context, chatID int64) (currency.Currency, error) {
+	ledger, err := c.db.GetLedger(ctx, chatID)
+	if errors.Is(err, database.ErrLedgerNotFound) {
+		return c.defaultBase, nil
+	}
+	if err != nil {
+		return currency.Currency{}, err
+	}
+
+	cur, ok := c.currencies.Get(ledger.BaseCurrency)
+	if !ok {
+		// Currency could be removed from config, but ledger is still readable
+		cur = currency.Currency{Code: ledger.BaseCurrency}
+	}
+	cur.Decimals = ledger.BaseDecimals
+	return cur, nil
+}
+
+// parseCurrency finds currency in registry, error lists known currencies
+func (c Core) parseCurrency(s string) (currency.Currency, error) {
+	cur, ok := c.currencies.Parse(s)
+	if !ok {
+		return currency.Currency{}, fmt.Errorf("%w: %s", errUnknownCurrency, s)
+	}
+	return cur, nil
 }
@@ -1,10 +1,13 @@
 package core
 
 import (
+	"context"
 	"fmt"
 	"moneyjar/pkg/config"
+	"moneyjar/pkg/currency"
 	"moneyjar/pkg/database"
-	"net/http"
+	"moneyjar/pkg/rates"
+	"sync"
 	"time"
 
 	"gopkg.in/telebot.v3"
@@ -13,12 +16,10 @@ import (
 const (
 	commandTimeout = 10 * time.Second
 	apiTimeout     = 15 * time.Second
-)
 
-// Getter represents http.Get interface
-type Getter interface {
-	Get(string) (*http.Response, error)
-}
+	defaultRateCacheTTL = time.Hour
+	defaultProposalTTL  = 24 * time.Hour
+)
 
 // Core contains business logic of bot
 type Core struct {
@@ -28,13 +29,53 @@ type Core struct {
 	commands []telebot.Command
 	messages map[string]string
 
-	apiKey     string
-	httpClient Getter
+	currencies  *currency.Registry
+	defaultBase currency.Currency
+	rates       rates.Provider
+	rateCache   *rates.Cache
+
+	// proposalTTL is time debtors have to confirm debt in chats with confirmation
+	proposalTTL time.Duration
+
+	// knownNames are names of users by ID which are already stored in database
+	knownNames *sync.Map
 }
 
 // New returns new Core
 func New(db database.Provider, tg *telebot.Bot, msgs map[string]string) (*Core, error) {
-	apiKey := config.C.String("api_key")
+	currencies, err := newCurrencyRegistry()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load currencies: %v", err)
+	}
+
+	defaultBaseCode := config.C.String("ledger.base_currency")
+	if defaultBaseCode == "" {
+		defaultBaseCode = defaultBaseCurrency
+	}
+	defaultBase, ok := currencies.Get(defaultBaseCode)
+	if !ok {
+		return nil, fmt.Errorf("base currency %s is not in list of currencies", defaultBaseCode)
+	}
+
+	cacheTTL := config.C.Duration("rates.cache_ttl")
+	if cacheTTL == 0 {
+		cacheTTL = defaultRateCacheTTL
+	}
+	rateProvider, rateCache, err := newRateProvider(defaultBase.Code, rateStore{db: db}, cacheTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init rate providers: %v", err)
+	}
+
+	proposalTTL := config.C.Duration("confirmations.ttl")
+	if proposalTTL == 0 {
+		proposalTTL = defaultProposalTTL
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	if err = rateCache.Load(ctx); err != nil {
+		return nil, fmt.Errorf("failed to load cached rates: %v", err)
+	}
 
 	c := &Core{
 		db: db,
@@ -43,15 +84,43 @@ func New(db database.Provider, tg *telebot.Bot, msgs map[string]string) (*Core,
 		commands: make([]telebot.Command, 0),
 		messages: msgs,
 
-		apiKey:     apiKey,
-		httpClient: &http.Client{Timeout: apiTimeout},
+		currencies:  currencies,
+		defaultBase: defaultBase,
+		rates:       rateProvider,
+		rateCache:   rateCache,
+
+		proposalTTL: proposalTTL,
+
+		knownNames: &sync.Map{},
 	}
 
+	// Middleware is applied only to handlers added after it, so plain messages refresh names as well
+	c.tg.Use(c.refreshNames)
+	c.tg.Handle(telebot.OnText, c.textMessage)
+	c.tg.Handle(telebot.OnEdited, c.editedMessage)
+
 	c.addCommand("/register", "Зарегистрироваться в боте", c.registerCommand)
-	c.addCommand("/debt", "Добавить долг для @пользователя", c.debtCommand)
+	c.addCommand("/addmember", "Добавить участника без Telegram по имени", c.addMemberCommand)
+	c.addCommand("/link", "Привязать добавленного вами участника без Telegram к себе, админ может указать @пользователя", c.linkCommand)
+	c.addCommand("/debt", "Добавить долг для @пользователя, можно начать с даты или \"вчера\"", c.debtCommand)
+	c.addCommand("/settle", "Вернуть долг @пользователю, можно указать сумму", c.settleCommand)
+	c.addCommand("/simplify", "Минимальный план взаиморасчетов, apply чтобы применить (только админ)", c.simplifyCommand)
+	c.addCommand("/confirm", "Записывать долги только после подтверждения должников, админ включает on или off", c.confirmCommand)
+	c.addCommand("/edit", "Исправить долг по номеру: сумму, валюту, участников или комментарий", c.editCommand)
+	c.addCommand("/dispute", "Оспорить операцию по номеру с причиной или показать спор", c.disputeCommand)
+	c.addCommand("/resolve", "Закрыть спор по операции, только для админов", c.resolveCommand)
+	c.addCommand("/undo", "Отменить свою последнюю операцию", c.undoCommand)
+	c.addCommand("/revert", "Отменить операцию по номеру из истории", c.revertCommand)
+	c.addCommand("/base", "Валюта учета чата, админ может сменить её с курсом пересчета", c.baseCommand)
+	c.addCommand("/currency", "Валюта, в которой показываются ваши балансы", c.currencyCommand)
+	c.addCommand("/rate", "Закрепить курс валюты в чате, off чтобы открепить", c.rateCommand)
+	c.addCommand("/rates", "Курсы валют в кэше", c.ratesCommand)
 	c.addCommand("/balance", "Текущие счета", c.balanceCommand)
 	c.addCommand("/history", "История операция, можно указать страницу", c.historyCommand)
 
+	c.tg.Handle(&acceptDebtButton, c.acceptDebtCallback)
+	c.tg.Handle(&declineDebtButton, c.declineDebtCallback)
+
 	if err := c.tg.SetCommands(c.commands); err != nil {
 		return nil, fmt.Errorf("failed to set telegram commands: %v", err)
 	}
@@ -70,5 +139,6 @@ func (c *Core) addCommand(cmd, description string, handler telebot.HandlerFunc)
 
 // Run starts telegram bot
 func (c Core) Run() {
+	go c.expireProposals()
 	c.tg.Start()
 }
@@ -0,0 +1,61 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"moneyjar/pkg/database"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	tg "gopkg.in/telebot.v3"
+)
+
+// currencyResetArg returns display currency to ledger base currency
+const currencyResetArg = "base"
+
+func (c Core) currencyCommand(tgCtx tg.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	userID := int(tgCtx.Sender().ID)
+	payload := strings.TrimSpace(tgCtx.Message().Payload)
+
+	settings, err := c.db.GetUserSettings(ctx, userID)
+	if err != nil {
+		log.Errorf("failed to get user settings: %v", err)
+		msg := c.messages["failedToGetSettings"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	if payload == "" {
+		msg := c.displayCurrencyMessage(settings)
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	settings.DisplayCurrency = ""
+	if strings.ToLower(payload) != currencyResetArg {
+		cur, err := c.parseCurrency(payload)
+		if err != nil {
+			msg := fmt.Sprintf(c.messages["unknownCurrency"], strings.Join(c.currencies.Codes(), ", "))
+			return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+		}
+		settings.DisplayCurrency = cur.Code
+	}
+
+	if err = c.db.SaveUserSettings(ctx, settings); err != nil {
+		log.Errorf("failed to save user settings: %v", err)
+		msg := c.messages["failedToSaveSettings"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	msg := c.displayCurrencyMessage(settings)
+	return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+}
+
+// displayCurrencyMessage describes currency user sees balances in
+func (c Core) displayCurrencyMessage(settings database.UserSettings) string {
+	if settings.DisplayCurrency == "" {
+		return c.messages["displayCurrencyBase"]
+	}
+	return fmt.Sprintf(c.messages["displayCurrency"], settings.DisplayCurrency)
+}
@@ -5,26 +5,44 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"math/big"
+	"moneyjar/pkg/currency"
 	"moneyjar/pkg/database"
+	"moneyjar/pkg/money"
+	"moneyjar/pkg/rates"
 	"regexp"
-	"strconv"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	tg "gopkg.in/telebot.v3"
 )
 
 var (
-	reDebtPayload   = regexp.MustCompile(`([-\d.,]+) ?([\wа-яА-Я$₽₾]+) ([@\w, ]+);? ?([\wа-яА-Я ]+)?`)
-	reMentionsArray = regexp.MustCompile(`@(\w+)`)
+	reDate       = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	reDottedDate = regexp.MustCompile(`^\d{2}\.\d{2}\.\d{4}$`)
 
 	errFailedToGetAllAccounts = errors.New(`failed to get all accounts`)
 )
 
+// relativeDays are words for dates relative to today
+var relativeDays = map[string]int{
+	"сегодня":   0,
+	"вчера":     -1,
+	"позавчера": -2,
+}
+
 type debtPayload struct {
-	amount   float64
-	currency currency
+	amount   money.Amount
+	currency currency.Currency
 	accounts []database.Account
-	comment  string
+	// ratios are parts of amount owed by accounts with the same index
+	ratios  []*big.Rat
+	comment string
+	// date is date of backdated expense, it's zero for expenses of today
+	date time.Time
+	// expression is arithmetic expression amount was evaluated from, it's empty for plain numbers
+	expression string
 }
 
 func (c Core) debtCommand(tgCtx tg.Context) error {
@@ -36,35 +54,59 @@ func (c Core) debtCommand(tgCtx tg.Context) error {
 		return nil
 	}
 
-	debt, err := c.parsePayload(ctx, tgCtx)
+	payload := mentionsByID(tgCtx.Message())
+	debt, err := c.parsePayload(ctx, tgCtx, payload)
 	if err != nil {
 		log.Errorf("failed to parse payload: %v", err)
-
-		var msg string
-		switch {
-		case errors.Is(sql.ErrNoRows, err):
-			msg = c.messages["unknownUsersInPayload"]
-		case errors.Is(errFailedToGetAllAccounts, err):
-			msg = c.messages["failedToGetAccounts"]
-		default:
-			msg = c.messages["failedToParsePayload"]
-		}
-		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+		return c.sendParseError(tgCtx, err, payload)
 	}
 
 	// Skip useless debts
-	if debt.amount == 0.0 {
+	if debt.amount.IsZero() {
 		return nil
 	}
 
-	usdAmount, err := c.convertToUSD(debt.currency, debt.amount)
+	chatID := tgCtx.Chat().ID
+	base, err := c.ledgerCurrency(ctx, chatID)
 	if err != nil {
-		log.Errorf("failed to convert currency to USD: %v", err)
+		log.Errorf("failed to get ledger currency: %v", err)
+		msg := c.messages["failedToGetLedger"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	baseAmount, quote, err := c.convertToBase(ctx, chatID, debt.currency, debt.amount, base, debt.date)
+	if err != nil {
+		log.Errorf("failed to convert currency to %s: %v", base.Code, err)
 		msg := c.messages["failedToConvertCurrency"]
 		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
 	}
 
-	updateAccounts, err := c.db.UpdateAccounts(ctx, debt.accounts, usdAmount, debt.comment)
+	op := &database.Operation{
+		ChatID:   chatID,
+		AuthorID: int(tgCtx.Sender().ID),
+		Kind:     database.KindExpense,
+		Comment:  debt.comment,
+
+		Currency:      debt.currency.Code,
+		ExchangeRate:  quote.Rate,
+		RateSource:    quote.Source,
+		EffectiveDate: debt.date,
+		MessageID:     tgCtx.Message().ID,
+	}
+	transfers := debt.transfers(baseAmount)
+	operation := debt.describe(quote)
+
+	confirm, err := c.confirmDebts(ctx, chatID)
+	if err != nil {
+		log.Errorf("failed to get ledger: %v", err)
+		msg := c.messages["failedToGetLedger"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+	if confirm && needsConfirmation(transfers) {
+		return c.proposeDebt(ctx, tgCtx, *op, transfers, operation)
+	}
+
+	updateAccounts, err := c.db.UpdateAccounts(ctx, op, transfers)
 	if err != nil {
 		log.Errorf("failed to update accounts: %v", err)
 		msg := c.messages["failedToUpdateBalance"]
@@ -77,71 +119,196 @@ func (c Core) debtCommand(tgCtx tg.Context) error {
 		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
 	}
 
-	var msg = "Баланс обновлен успешно: \n"
-	msg += generateBalanceMessage(updateAccounts)
-	return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message(), ParseMode: tg.ModeHTML})
+	var msg = fmt.Sprintf("Баланс обновлен успешно (#%d, %s): \n", op.ID, operation)
+	msg += generateBalanceMessage(updateAccounts, c.userDisplay(ctx, int(tgCtx.Sender().ID), base))
+	msg += c.pendingUsersNote(updateAccounts)
+	msg += c.rateWarning(quote)
+	return c.replyOperation(ctx, tgCtx, op.ID, msg)
+}
+
+// sendParseError replies with reason why debt can't be parsed from payload
+func (c Core) sendParseError(tgCtx tg.Context, err error, payload string) error {
+	var (
+		msg       string
+		syntaxErr *syntaxError
+	)
+	switch {
+	case errors.As(err, &syntaxErr):
+		msg = fmt.Sprintf(c.messages["debtSyntaxError"], syntaxErr.pos, syntaxErr.reason)
+		msg += "\n" + syntaxErrorPointer(payload, syntaxErr)
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message(), ParseMode: tg.ModeHTML})
+	case errors.Is(err, sql.ErrNoRows):
+		msg = c.messages["authorNotRegistered"]
+	case errors.Is(err, database.ErrUserNotRegistered):
+		msg = c.messages["userNotRegistered"]
+	case errors.Is(err, errFailedToGetAllAccounts):
+		msg = c.messages["failedToGetAccounts"]
+	case errors.Is(err, errUnknownCurrency):
+		msg = fmt.Sprintf(c.messages["unknownCurrency"], strings.Join(c.currencies.Codes(), ", "))
+	default:
+		msg = c.messages["failedToParsePayload"]
+	}
+	return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+}
+
+// transfers splits debt between its accounts, baseAmount is amount of debt in ledger base currency
+func (d debtPayload) transfers(baseAmount money.Amount) []database.Transfer {
+	_, baseShares := splitAmount(baseAmount, d.ratios)
+	_, originalShares := splitAmount(d.amount, d.ratios)
+	transfers := make([]database.Transfer, 0, len(d.accounts))
+	for i, account := range d.accounts {
+		transfers = append(transfers, database.Transfer{
+			Account:        account,
+			Amount:         baseShares[i],
+			OriginalAmount: originalShares[i],
+		})
+	}
+	return transfers
 }
 
-func (c Core) parsePayload(ctx context.Context, tgCtx tg.Context) (*debtPayload, error) {
-	match := reDebtPayload.FindStringSubmatch(tgCtx.Message().Payload)
-	if len(match) < 5 {
-		return nil, fmt.Errorf("invalid payload: %d of 5 matches", len(match))
+// describe returns amount of debt as it's shown to users, rate is shown for backdated debts
+func (d debtPayload) describe(quote rates.Quote) string {
+	operation := d.currency.FormatWords(d.amount)
+	if d.expression != "" {
+		operation = d.expression + " = " + operation
+	}
+	switch {
+	case d.date.IsZero():
+	case quote.Fallback:
+		operation += fmt.Sprintf(" за %s по текущему курсу %.4f", d.date.Format(dateLayout), quote.Rate)
+	default:
+		operation += fmt.Sprintf(" за %s по курсу %.4f", d.date.Format(dateLayout), quote.Rate)
 	}
-	amount, err := strconv.ParseFloat(match[1], 64)
+	return operation
+}
+
+// parsePayload parses debt of sender from payload where mentions are replaced by mentionsByID
+func (c Core) parsePayload(ctx context.Context, tgCtx tg.Context, payload string) (*debtPayload, error) {
+	syntax, err := parseDebtSyntax(payload, time.Now())
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse amount: %v", err)
+		return nil, err
 	}
 
-	cur := parseCurrency(match[2])
-	if cur == "" {
-		return nil, fmt.Errorf("unknown currency: %s", match[1])
+	cur, err := c.parseAmountCurrency(syntax.symbol, syntax.currency)
+	if err != nil {
+		return nil, err
 	}
 
-	var accounts []database.Account
+	// Result of division can have endless fraction, so amount is rounded to what can be paid
+	amount, err := money.FromRat(syntax.amount, cur.Decimals)
+	if err != nil {
+		return nil, err
+	}
 
-	if match[3] == "@all" {
-		fromUser := int(tgCtx.Sender().ID)
-		accounts, err = c.db.GetAccountsWithUser(ctx, fromUser)
+	var (
+		accounts []database.Account
+		targets  = syntax.targets
+		chatID   = tgCtx.Chat().ID
+		fromUser = int(tgCtx.Sender().ID)
+	)
+
+	if syntax.all {
+		accounts, err = c.db.GetAccountsWithUser(ctx, chatID, fromUser)
 		if err != nil {
 			log.Errorf("failed to get all accounts: %v", err)
 			return nil, fmt.Errorf("%w: %v", errFailedToGetAllAccounts, err)
 		}
-	} else {
-		toUsernames, err := parseMentions(match[3])
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse mentions string: %v", err)
+		for i := range accounts {
+			// Merged accounts can be stored from the other side, but debt is always added on the author's side
+			if accounts[i].FromUser != fromUser {
+				accounts[i].FromUser, accounts[i].ToUser = accounts[i].ToUser, accounts[i].FromUser
+				accounts[i].FromUserName, accounts[i].ToUserName = accounts[i].ToUserName, accounts[i].FromUserName
+			}
+			targets = append(targets, splitTarget{username: accounts[i].ToUserName})
 		}
-
-		fromUser := int(tgCtx.Sender().ID)
-
-		for _, toUsername := range toUsernames {
-			account, err := c.db.UserNameToAccount(ctx, fromUser, toUsername)
+	} else {
+		for _, target := range targets {
+			account, err := c.mentionToAccount(ctx, chatID, fromUser, target.username)
+			if errors.Is(err, database.ErrUserNotRegistered) && c.canBePending(tgCtx.Sender(), target.username) {
+				// Debt waits in pending account until the user runs /register
+				account = database.Account{ChatID: chatID, FromUser: fromUser, ToUserName: target.username, IsPending: true}
+				err = nil
+			}
 			if err != nil {
-				return nil, fmt.Errorf("failed to get userId from name %s: %v", match[3], err)
+				return nil, fmt.Errorf("failed to get userId from name %s: %w", target.username, err)
 			}
 			accounts = append(accounts, account)
 		}
 	}
 
+	// Split is checked by parser against amount as it was written, so it's computed from the same amount
+	ratios, err := splitRatios(syntax.amount, targets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to split amount: %v", err)
+	}
+
 	return &debtPayload{
 		amount:   amount,
 		currency: cur,
 		accounts: accounts,
-		comment:  match[4],
+		ratios:   ratios,
+		comment:  syntax.comment,
+		date:     syntax.date,
+
+		expression: syntax.expression,
 	}, nil
 }
 
-func parseMentions(mentions string) (usernames []string, err error) {
-	match := reMentionsArray.FindAllStringSubmatch(mentions, -1)
-	if len(match) < 1 {
-		return nil, fmt.Errorf("bad string: %s", mentions)
+// canBePending reports if debt to username can wait for registration. Mentions by ID are made only for
+// users without username, so they can't be claimed by /register, and debt to oneself makes no sense.
+func (c Core) canBePending(sender *tg.User, username string) bool {
+	if _, ok := mentionedID(username); ok {
+		return false
+	}
+	return !strings.EqualFold(username, sender.Username)
+}
+
+// parseAmountCurrency returns currency written before amount as symbol, e.g. $20, or after amount
+func (c Core) parseAmountCurrency(prefix, suffix string) (currency.Currency, error) {
+	switch {
+	case prefix == "" && suffix == "":
+		return currency.Currency{}, fmt.Errorf("%w: currency is not set", errUnknownCurrency)
+	case prefix == "":
+		return c.parseCurrency(suffix)
+	}
+
+	cur, err := c.parseCurrency(prefix)
+	if err != nil {
+		return currency.Currency{}, err
 	}
-	for _, mention := range match {
-		if len(mention) < 2 {
-			log.Warnf("mention matched, but didnt have match group: %s", mention)
-			continue
+	if suffix != "" {
+		if other, err := c.parseCurrency(suffix); err != nil || other.Code != cur.Code {
+			return currency.Currency{}, fmt.Errorf("currencies %s and %s do not match", prefix, suffix)
 		}
-		usernames = append(usernames, mention[1])
 	}
-	return usernames, nil
+	return cur, nil
+}
+
+// parseDate parses date of expense: "2026-10-01", "01.10.2026" or words like "вчера".
+// False is returned if word does not look like a date.
+func parseDate(word string, now time.Time) (time.Time, bool, error) {
+	word = strings.ToLower(word)
+	if days, ok := relativeDays[word]; ok {
+		return startOfDay(now).AddDate(0, 0, days), true, nil
+	}
+
+	var layout string
+	switch {
+	case reDate.MatchString(word):
+		layout = "2006-01-02"
+	case reDottedDate.MatchString(word):
+		layout = dateLayout
+	default:
+		return time.Time{}, false, nil
+	}
+
+	date, err := time.ParseInLocation(layout, word, now.Location())
+	if err != nil {
+		return time.Time{}, true, fmt.Errorf("failed to parse date: %v", err)
+	}
+	return date, true, nil
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
 }
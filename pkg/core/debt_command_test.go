@@ -1,68 +1,396 @@
 package core
 
 import (
-	"reflect"
+	"math/big"
+	"moneyjar/pkg/currency"
+	"moneyjar/pkg/database"
+	"moneyjar/pkg/money"
+	"moneyjar/pkg/rates"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
-func Test_parseMentions(t *testing.T) {
-	type args struct {
-		mentions string
-	}
+func Test_parseDebtSyntax(t *testing.T) {
+	now := time.Date(2026, 10, 18, 15, 30, 0, 0, time.UTC)
+
 	tests := []struct {
-		name          string
-		args          args
-		wantUsernames []string
-		wantErr       bool
+		name    string
+		payload string
+		want    *debtSyntax
+		wantPos int
 	}{
 		{
-			name:          "single mention",
-			args:          args{mentions: "@test_user"},
-			wantUsernames: []string{"test_user"},
+			name:    "single mention",
+			payload: `-100 gel @test`,
+			want:    &debtSyntax{amount: rat("-100"), currency: "gel", targets: []splitTarget{{username: "test"}}},
+		},
+		{
+			name:    "multiple mentions",
+			payload: `100 gel @test_user, @test_user2`,
+			want: &debtSyntax{amount: rat("100"), currency: "gel", targets: []splitTarget{
+				{username: "test_user"}, {username: "test_user2"},
+			}},
+		},
+		{
+			name:    "comment",
+			payload: `100 gel @test; comment коммент`,
+			want: &debtSyntax{
+				amount: rat("100"), currency: "gel", targets: []splitTarget{{username: "test"}}, comment: "comment коммент",
+			},
+		},
+		{
+			name:    "comment with digits and punctuation",
+			payload: `100 gel @test; ужин в 8, 2 пиццы (и десерт)!`,
+			want: &debtSyntax{
+				amount: rat("100"), currency: "gel", targets: []splitTarget{{username: "test"}},
+				comment: "ужин в 8, 2 пиццы (и десерт)!",
+			},
 		},
 		{
-			name:          "multiple mentions",
-			args:          args{mentions: "@test_user, @test_user2"},
-			wantUsernames: []string{"test_user", "test_user2"},
+			name:    "comment without semicolon",
+			payload: `100 gel @test такси`,
+			want: &debtSyntax{
+				amount: rat("100"), currency: "gel", targets: []splitTarget{{username: "test"}}, comment: "такси",
+			},
+		},
+		{
+			name:    "all",
+			payload: `100 gel @all; ужин`,
+			want:    &debtSyntax{amount: rat("100"), currency: "gel", all: true, comment: "ужин"},
+		},
+		{
+			name:    "exact amounts",
+			payload: `100 gel @a=30 @b=20.5, @c=30; ужин`,
+			want: &debtSyntax{amount: rat("100"), currency: "gel", comment: "ужин", targets: []splitTarget{
+				{username: "a", mode: splitExact, value: rat("30")},
+				{username: "b", mode: splitExact, value: rat("20.5")},
+				{username: "c", mode: splitExact, value: rat("30")},
+			}},
+		},
+		{
+			name:    "percents",
+			payload: `100 gel @a 30% @b=20%`,
+			want: &debtSyntax{amount: rat("100"), currency: "gel", targets: []splitTarget{
+				{username: "a", mode: splitPercent, value: rat("30")},
+				{username: "b", mode: splitPercent, value: rat("20")},
+			}},
+		},
+		{
+			name:    "number after mention starts comment",
+			payload: `500 rub @vasya 3 пива`,
+			want: &debtSyntax{
+				amount: rat("500"), currency: "rub", targets: []splitTarget{{username: "vasya"}}, comment: "3 пива",
+			},
+		},
+		{
+			name:    "mention after explicit comment",
+			payload: `500 rub @vasya; пиво с @petya и mail@example.com`,
+			want: &debtSyntax{
+				amount: rat("500"), currency: "rub", targets: []splitTarget{{username: "vasya"}},
+				comment: "пиво с @petya и mail@example.com",
+			},
+		},
+		{
+			name:    "weights",
+			payload: `100 gel @a*2 @b`,
+			want: &debtSyntax{amount: rat("100"), currency: "gel", targets: []splitTarget{
+				{username: "a", mode: splitShares, value: rat("2")},
+				{username: "b"},
+			}},
+		},
+		{
+			name:    "expression",
+			payload: `(12.5*3+4)/2 usd @a`,
+			want: &debtSyntax{
+				amount: rat("20.75"), expression: "(12.5*3+4)/2", currency: "usd", targets: []splitTarget{{username: "a"}},
+			},
+		},
+		{
+			name:    "expression with spaces",
+			payload: `12.5 * 3 + 4 usd @a`,
+			want: &debtSyntax{
+				amount: rat("41.5"), expression: "12.5 * 3 + 4", currency: "usd", targets: []splitTarget{{username: "a"}},
+			},
+		},
+		{
+			name:    "comma decimal",
+			payload: `12,50 gel @a`,
+			want:    &debtSyntax{amount: rat("12.5"), currency: "gel", targets: []splitTarget{{username: "a"}}},
+		},
+		{
+			name:    "thousands with spaces",
+			payload: `1 200 000 руб @a`,
+			want:    &debtSyntax{amount: rat("1200000"), currency: "руб", targets: []splitTarget{{username: "a"}}},
+		},
+		{
+			name:    "thousand suffix",
+			payload: `1.5k kzt @a`,
+			want:    &debtSyntax{amount: rat("1500"), currency: "kzt", targets: []splitTarget{{username: "a"}}},
+		},
+		{
+			name:    "symbol before amount",
+			payload: `$20 @a`,
+			want:    &debtSyntax{amount: rat("20"), symbol: "$", targets: []splitTarget{{username: "a"}}},
+		},
+		{
+			name:    "symbol before amount with space",
+			payload: `₾ 15 @a; хачапури`,
+			want: &debtSyntax{
+				amount: rat("15"), symbol: "₾", targets: []splitTarget{{username: "a"}}, comment: "хачапури",
+			},
+		},
+		{
+			name:    "date",
+			payload: `вчера 100 gel @a`,
+			want: &debtSyntax{
+				date:   time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC),
+				amount: rat("100"), currency: "gel", targets: []splitTarget{{username: "a"}},
+			},
+		},
+		{
+			name:    "today is not backdated",
+			payload: `18.10.2026 100 gel @a`,
+			want:    &debtSyntax{amount: rat("100"), currency: "gel", targets: []splitTarget{{username: "a"}}},
+		},
+		{
+			name:    "future date",
+			payload: `2026-10-19 100 gel @a`,
+			wantPos: 1,
+		},
+		{
+			name:    "bad amount",
+			payload: `вчера 1.2.3 gel @a`,
+			wantPos: 7,
+		},
+		{
+			name:    "no currency",
+			payload: `100 @a`,
+			wantPos: 5,
+		},
+		{
+			name:    "no targets",
+			payload: `100 gel; ужин`,
+			wantPos: 8,
+		},
+		{
+			name:    "no targets at end",
+			payload: `100 gel`,
+			wantPos: 8,
+		},
+		{
+			name:    "bad weight",
+			payload: `100 gel @a*x`,
+			wantPos: 9,
+		},
+		{
+			name:    "all with others",
+			payload: `100 лари @a @all`,
+			wantPos: 13,
+		},
+		{
+			name:    "exact amount more than debt",
+			payload: `100 usd @a=200`,
+			wantPos: 9,
+		},
+		{
+			name:    "exact amounts sum more than debt",
+			payload: `100 usd @a=60 @b=50`,
+			wantPos: 15,
+		},
+		{
+			name:    "exact amount not set for everyone",
+			payload: `100 usd @a=60 @b`,
+			wantPos: 15,
+		},
+		{
+			name:    "bad exact amount",
+			payload: `100 usd @a=x`,
+			wantPos: 9,
+		},
+		{
+			name:    "amount overflows",
+			payload: `123456789012345678 usd @a`,
+			wantPos: 1,
+		},
+		{
+			name:    "overflow after date",
+			payload: `вчера 99999999999999999999 usd @a`,
+			wantPos: 7,
+		},
+		{
+			name:    "mention after number in comment",
+			payload: `50 usd @a 30 @b 20`,
+			wantPos: 14,
+		},
+		{
+			name:    "empty mention",
+			payload: `100 gel @ @a`,
+			wantPos: 9,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotUsernames, err := parseMentions(tt.args.mentions)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("parseMentions() error = %v, wantErr %v", err, tt.wantErr)
+			got, err := parseDebtSyntax(tt.payload, now)
+			if tt.wantPos > 0 {
+				var syntaxErr *syntaxError
+				if assert.ErrorAs(t, err, &syntaxErr) {
+					assert.Equal(t, tt.wantPos, syntaxErr.pos)
+				}
 				return
 			}
-			if !reflect.DeepEqual(gotUsernames, tt.wantUsernames) {
-				t.Errorf("parseMentions() gotUsernames = %v, want %v", gotUsernames, tt.wantUsernames)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_parseNumber(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{input: "12", want: "12.00"},
+		{input: "12.50", want: "12.50"},
+		{input: "12,50", want: "12.50"},
+		{input: "1 200", want: "1200.00"},
+		{input: "1\u00a0200", want: "1200.00"},
+		{input: "1 200,50", want: "1200.50"},
+		{input: "1.200,50", want: "1200.50"},
+		{input: "1,200.50", want: "1200.50"},
+		{input: "1.200.300", want: "1200300.00"},
+		{input: "1,200,300.5", want: "1200300.50"},
+		{input: "1,200", want: "1.20"},
+		{input: ".5", want: "0.50"},
+		{input: "1.5k", want: "1500.00"},
+		{input: "2к", want: "2000.00"},
+		{input: "1 200k", want: "1200000.00"},
+		{input: "1.2.3", wantErr: true},
+		{input: "1 20", wantErr: true},
+		{input: "1234 567", wantErr: true},
+		{input: "1.200 300", wantErr: true},
+		{input: "12,", wantErr: true},
+		{input: "1,2.3,4", wantErr: true},
+		{input: "k", wantErr: true},
+		{input: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseNumber(tt.input)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, errBadAmount)
+				return
 			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got.FloatString(2))
+		})
+	}
+}
+
+func Test_evalExpression_locale(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: "12,50*2", want: "25.00"},
+		{input: "1 200+300", want: "1500.00"},
+		{input: "1.200,50-0,50", want: "1200.00"},
+		{input: "1.5k/3", want: "500.00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := evalExpression(tt.input)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got.FloatString(2))
 		})
 	}
 }
 
-func Test_reDebtPayload(t *testing.T) {
-	var match []string
+func Test_parseDate(t *testing.T) {
+	now := time.Date(2026, 10, 18, 15, 30, 0, 0, time.UTC)
 
-	var (
-		singleMention         = `-100 gel @test`
-		expectedSingleMention = []string{singleMention, "-100", "gel", "@test", ""}
-	)
-	match = reDebtPayload.FindStringSubmatch(singleMention)
-	assert.Equal(t, expectedSingleMention, match)
+	tests := []struct {
+		word       string
+		wantDate   time.Time
+		wantIsDate bool
+		wantErr    bool
+	}{
+		{word: "100"},
+		{word: "такси"},
+		{word: "2026-10-01", wantDate: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), wantIsDate: true},
+		{word: "01.10.2026", wantDate: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), wantIsDate: true},
+		{word: "Вчера", wantDate: time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC), wantIsDate: true},
+		{word: "позавчера", wantDate: time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC), wantIsDate: true},
+		{word: "2026-13-01", wantIsDate: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.word, func(t *testing.T) {
+			date, isDate, err := parseDate(tt.word, now)
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.wantIsDate, isDate)
+			assert.Equal(t, tt.wantDate, date)
+		})
+	}
+}
+
+func rat(s string) *big.Rat {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		panic("bad rational number " + s)
+	}
+	return r
+}
+
+func Test_debtPayload_transfers(t *testing.T) {
+	debt := debtPayload{
+		amount: money.New(1000, 2),
+		accounts: []database.Account{
+			{FromUser: 1, ToUser: 2},
+			{FromUser: 1, ToUser: 3},
+		},
+		ratios: []*big.Rat{big.NewRat(1, 3), big.NewRat(1, 3)},
+	}
+
+	transfers := debt.transfers(money.New(370, 2))
+	assert.Len(t, transfers, 2)
+	assert.Equal(t, "3.33", transfers[0].OriginalAmount.String())
+	assert.Equal(t, "1.23", transfers[0].Amount.String())
+	assert.Equal(t, database.Account{FromUser: 1, ToUser: 3}, transfers[1].Account)
+}
 
-	var (
-		multipleMention         = `100 gel @test1 @test2`
-		expectedMultipleMention = []string{multipleMention, "100", "gel", "@test1 @test2", ""}
-	)
-	match = reDebtPayload.FindStringSubmatch(multipleMention)
-	assert.Equal(t, expectedMultipleMention, match)
+func Test_debtPayload_describe(t *testing.T) {
+	gel := currency.Currency{Code: "GEL", Symbols: []string{"₾"}, Decimals: 2}
 
-	var (
-		comment         = `100 gel @test; comment коммент`
-		expectedComment = []string{comment, "100", "gel", "@test", "comment коммент"}
-	)
-	match = reDebtPayload.FindStringSubmatch(comment)
-	assert.Equal(t, expectedComment, match)
+	tests := []struct {
+		name  string
+		debt  debtPayload
+		quote rates.Quote
+		want  string
+	}{
+		{
+			name:  "today",
+			debt:  debtPayload{amount: money.New(1000, 2), currency: gel},
+			quote: rates.Quote{Rate: 0.37},
+			want:  gel.FormatWords(money.New(1000, 2)),
+		},
+		{
+			name:  "backdated",
+			debt:  debtPayload{amount: money.New(1000, 2), currency: gel, date: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)},
+			quote: rates.Quote{Rate: 0.37},
+			want:  gel.FormatWords(money.New(1000, 2)) + " за 01.10.2026 по курсу 0.3700",
+		},
+		{
+			name:  "backdated at current rate",
+			debt:  debtPayload{amount: money.New(1000, 2), currency: gel, date: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)},
+			quote: rates.Quote{Rate: 0.37, Fallback: true},
+			want:  gel.FormatWords(money.New(1000, 2)) + " за 01.10.2026 по текущему курсу 0.3700",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.debt.describe(tt.quote))
+		})
+	}
 }
@@ -0,0 +1,381 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"math/big"
+	"moneyjar/pkg/currency"
+	"moneyjar/pkg/money"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// debtSyntax is parsed payload of /debt:
+//
+//	payload  = [date] [symbol] amount [currency] targets [";"] [comment]
+//	amount   = number | expression
+//	targets  = "@all" | target {[","] target}
+//	target   = "@" username ["*" weight | "=" number ["%"] | number "%"]
+//
+// Currency can be written as symbol before amount, e.g. $20, comment is everything after targets.
+// Number after target without "=" or "%" starts comment, e.g. "@a 3 пива". Mentions in comment have to be
+// separated by ";", otherwise "@a 30 @b 20" would silently drop @b.
+type debtSyntax struct {
+	date time.Time
+	// amount is evaluated amount, expression is set if it was not a plain number
+	amount     *big.Rat
+	expression string
+	// symbol is currency written before amount and currency is written after it
+	symbol   string
+	currency string
+	all      bool
+	targets  []splitTarget
+	comment  string
+}
+
+// syntaxError points to the token of payload which could not be parsed
+type syntaxError struct {
+	// pos is 1-based position of token in characters
+	pos   int
+	token string
+	// reason is shown to user as is
+	reason string
+}
+
+func (e *syntaxError) Error() string {
+	return fmt.Sprintf("syntax error at %d (%q): %s", e.pos, e.token, e.reason)
+}
+
+type debtToken struct {
+	text string
+	// start is offset of token in payload in bytes
+	start int
+}
+
+// tokenizeDebt splits payload by spaces, ";" is always a separate token and "@" starts a new one
+func tokenizeDebt(payload string) []debtToken {
+	var (
+		tokens []debtToken
+		start  = -1
+	)
+	flush := func(end int) {
+		if start >= 0 {
+			tokens = append(tokens, debtToken{text: payload[start:end], start: start})
+			start = -1
+		}
+	}
+
+	for i, r := range payload {
+		switch {
+		case unicode.IsSpace(r):
+			flush(i)
+		case r == ';':
+			flush(i)
+			tokens = append(tokens, debtToken{text: ";", start: i})
+		case r == '@':
+			flush(i)
+			start = i
+		case start < 0:
+			start = i
+		}
+	}
+	flush(len(payload))
+	return tokens
+}
+
+type debtParser struct {
+	payload string
+	tokens  []debtToken
+	next    int
+	// targetTokens are tokens of debtSyntax.targets with the same index
+	targetTokens []debtToken
+}
+
+// parseDebtSyntax parses /debt payload, *syntaxError is returned if it does not follow the grammar
+func parseDebtSyntax(payload string, now time.Time) (*debtSyntax, error) {
+	p := &debtParser{payload: payload, tokens: tokenizeDebt(payload)}
+	syntax := &debtSyntax{}
+
+	if err := p.parseDate(syntax, now); err != nil {
+		return nil, err
+	}
+	if err := p.parseAmount(syntax); err != nil {
+		return nil, err
+	}
+	if err := p.parseCurrency(syntax); err != nil {
+		return nil, err
+	}
+	if err := p.parseTargets(syntax); err != nil {
+		return nil, err
+	}
+	if err := p.checkSplit(syntax); err != nil {
+		return nil, err
+	}
+	if err := p.parseComment(syntax); err != nil {
+		return nil, err
+	}
+	return syntax, nil
+}
+
+func (p *debtParser) peek() (debtToken, bool) {
+	if p.next >= len(p.tokens) {
+		return debtToken{}, false
+	}
+	return p.tokens[p.next], true
+}
+
+// errorAt returns error pointing to token, end of payload is pointed if there are no more tokens
+func (p *debtParser) errorAt(t debtToken, ok bool, reason string) error {
+	if !ok {
+		return &syntaxError{pos: utf8.RuneCountInString(p.payload) + 1, reason: reason}
+	}
+	return &syntaxError{pos: utf8.RuneCountInString(p.payload[:t.start]) + 1, token: t.text, reason: reason}
+}
+
+func (p *debtParser) parseDate(syntax *debtSyntax, now time.Time) error {
+	t, ok := p.peek()
+	if !ok {
+		return nil
+	}
+
+	date, isDate, err := parseDate(t.text, now)
+	switch {
+	case !isDate:
+		return nil
+	case err != nil:
+		return p.errorAt(t, ok, "неверная дата, пишите как 2026-10-01, 01.10.2026 или вчера")
+	case date.After(now):
+		return p.errorAt(t, ok, "нельзя записать расход будущей датой")
+	}
+
+	p.next++
+	// Expenses of today are not backdated
+	if date.Before(startOfDay(now)) {
+		syntax.date = date
+	}
+	return nil
+}
+
+func (p *debtParser) parseAmount(syntax *debtSyntax) error {
+	t, ok := p.peek()
+	if !ok || t.text == ";" || strings.HasPrefix(t.text, "@") {
+		return p.errorAt(t, ok, "ожидается сумма")
+	}
+	p.next++
+	amountToken := t
+
+	// Currency symbol can be written before amount: $20 or ₾ 15
+	if prefix := amountPrefixLength(t.text); prefix > 0 {
+		syntax.symbol = t.text[:prefix]
+		amountToken = debtToken{text: t.text[prefix:], start: t.start + prefix}
+		if amountToken.text == "" {
+			amountToken, ok = p.peek()
+			if !ok || amountPrefixLength(amountToken.text) > 0 {
+				return p.errorAt(amountToken, ok, "ожидается сумма")
+			}
+			p.next++
+		}
+	}
+
+	// Expression can be written with spaces around operators and thousands can be separated by spaces
+	parts := []string{amountToken.text}
+	for {
+		t, ok := p.peek()
+		if !ok {
+			break
+		}
+		last := parts[len(parts)-1]
+		if !strings.ContainsAny(last[len(last)-1:], "+-*/(") &&
+			!strings.ContainsAny(t.text[:1], "+-*/)") &&
+			!isThousandsGroup(last, t.text) {
+			break
+		}
+		parts = append(parts, t.text)
+		p.next++
+	}
+
+	text := strings.Join(parts, " ")
+	amount, err := evalExpression(text)
+	if err != nil {
+		return p.errorAt(amountToken, true, "неверная сумма, пишите как 12.50, 12,50, 1 200, 1.5k или 12.5*3")
+	}
+	// Amount has to fit into minor units of any currency it can be written in
+	if _, err = money.FromRat(amount, currency.MaxDecimals); err != nil {
+		return p.errorAt(amountToken, true, "слишком большая сумма")
+	}
+	syntax.amount = amount
+	if isExpression(text) {
+		syntax.expression = text
+	}
+	return nil
+}
+
+func (p *debtParser) parseCurrency(syntax *debtSyntax) error {
+	t, ok := p.peek()
+	if ok && t.text != ";" && !strings.HasPrefix(t.text, "@") {
+		syntax.currency = t.text
+		p.next++
+		return nil
+	}
+	if syntax.symbol == "" {
+		return p.errorAt(t, ok, "ожидается валюта")
+	}
+	return nil
+}
+
+func (p *debtParser) parseTargets(syntax *debtSyntax) error {
+	for {
+		t, ok := p.peek()
+		if !ok || !strings.HasPrefix(t.text, "@") {
+			break
+		}
+		p.next++
+
+		text := strings.TrimSuffix(t.text, ",")
+		nameEnd := 1
+		for nameEnd < len(text) {
+			r, size := utf8.DecodeRuneInString(text[nameEnd:])
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+				break
+			}
+			nameEnd += size
+		}
+		if nameEnd == 1 {
+			return p.errorAt(t, ok, "ожидается имя пользователя после @")
+		}
+
+		target := splitTarget{username: text[1:nameEnd]}
+		switch modifier := text[nameEnd:]; {
+		case strings.HasPrefix(modifier, "*"):
+			weight, err := parseNumber(modifier[1:])
+			if err != nil || weight.Sign() <= 0 {
+				return p.errorAt(t, ok, "ожидается доля после *, например @user*2")
+			}
+			target.mode, target.value = splitShares, weight
+		case strings.HasPrefix(modifier, "="):
+			if err := setTargetAmount(&target, modifier[1:]); err != nil {
+				return p.errorAt(t, ok, "ожидается сумма или процент после =, например @user=30 или @user=30%")
+			}
+		case modifier != "":
+			return p.errorAt(t, ok, "неожиданные символы после имени пользователя")
+		default:
+			if err := p.parseTargetAmount(&target); err != nil {
+				return err
+			}
+		}
+
+		if target.username == "all" || syntax.all {
+			if len(syntax.targets) > 0 || syntax.all {
+				return p.errorAt(t, ok, "@all нельзя совмещать с другими пользователями")
+			}
+			syntax.all = true
+			continue
+		}
+		syntax.targets = append(syntax.targets, target)
+		p.targetTokens = append(p.targetTokens, t)
+	}
+
+	if !syntax.all && len(syntax.targets) == 0 {
+		t, ok := p.peek()
+		return p.errorAt(t, ok, "ожидается @пользователь")
+	}
+	return nil
+}
+
+// parseTargetAmount parses percent written after target, e.g. "@a 30%". Plain number is not taken,
+// it's a start of comment like "@a 3 пива", exact amount is written as "@a=30".
+func (p *debtParser) parseTargetAmount(target *splitTarget) error {
+	t, ok := p.peek()
+	if !ok {
+		return nil
+	}
+	text := strings.TrimSuffix(t.text, ",")
+	if !strings.HasSuffix(text, "%") || (!unicode.IsDigit(rune(text[0])) && text[0] != '.') {
+		return nil
+	}
+	p.next++
+
+	if err := setTargetAmount(target, text); err != nil {
+		return p.errorAt(t, ok, "неверный процент пользователя")
+	}
+	return nil
+}
+
+// setTargetAmount sets exact amount of target or percent if text ends with "%"
+func setTargetAmount(target *splitTarget, text string) error {
+	mode := splitExact
+	if strings.HasSuffix(text, "%") {
+		mode = splitPercent
+		text = strings.TrimSuffix(text, "%")
+	}
+	value, err := parseNumber(text)
+	if err != nil {
+		return err
+	}
+	target.mode, target.value = mode, value
+	return nil
+}
+
+// checkSplit points to target whose amount, percent or weight does not fit amount and other targets
+func (p *debtParser) checkSplit(syntax *debtSyntax) error {
+	if syntax.all {
+		return nil
+	}
+	_, err := splitRatios(syntax.amount, syntax.targets)
+	var splitErr *splitError
+	if errors.As(err, &splitErr) {
+		return p.errorAt(p.targetTokens[splitErr.index], true, splitErr.reason)
+	}
+	return err
+}
+
+func (p *debtParser) parseComment(syntax *debtSyntax) error {
+	t, ok := p.peek()
+	if !ok {
+		return nil
+	}
+	start := t.start
+	if t.text == ";" {
+		start++
+	} else {
+		for _, c := range p.tokens[p.next:] {
+			if c.text == ";" {
+				break
+			}
+			// "@" inside a word like e-mail is not a mention
+			before, _ := utf8.DecodeLastRuneInString(p.payload[:c.start])
+			if strings.HasPrefix(c.text, "@") && len(c.text) > 1 && unicode.IsSpace(before) {
+				return p.errorAt(c, true, "пользователь после комментария, пишите сумму как @user=30 или отделите комментарий через ;")
+			}
+		}
+	}
+	syntax.comment = strings.TrimSpace(p.payload[start:])
+	return nil
+}
+
+// amountPrefixLength returns length of currency symbol before amount in bytes
+func amountPrefixLength(s string) int {
+	for i, r := range s {
+		if unicode.IsDigit(r) || strings.ContainsRune("+-*/(.,", r) {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// isThousandsGroup reports if token continues number separated by space, e.g. "1 200"
+func isThousandsGroup(previous, token string) bool {
+	last, _ := utf8.DecodeLastRuneInString(previous)
+	if !unicode.IsDigit(last) || len(token) < 3 || !isDigits(token[:3]) {
+		return false
+	}
+	return len(token) == 3 || !unicode.IsDigit(rune(token[3]))
+}
+
+// syntaxErrorPointer shows payload with mark under the bad token
+func syntaxErrorPointer(payload string, err *syntaxError) string {
+	return "<code>" + html.EscapeString(payload) + "\n" + strings.Repeat(" ", err.pos-1) + "^</code>"
+}
@@ -0,0 +1,178 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"moneyjar/pkg/database"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	tg "gopkg.in/telebot.v3"
+)
+
+// reDisputePayload matches operation ID with optional text which can span several lines
+var reDisputePayload = regexp.MustCompile(`(?s)^#?(\d+)(?:\s+(.*\S))?\s*$`)
+
+func (c Core) disputeCommand(tgCtx tg.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	chatID := tgCtx.Chat().ID
+	userID := int(tgCtx.Sender().ID)
+
+	operationID, reason, err := parseDisputePayload(tgCtx.Message().Payload)
+	if err != nil {
+		log.Errorf("failed to parse dispute payload: %v", err)
+		msg := c.messages["disputeSyntax"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	// Without reason dispute thread is shown
+	var dispute database.Dispute
+	if reason == "" {
+		dispute, err = c.db.GetDispute(ctx, chatID, operationID)
+		if err != nil {
+			log.Errorf("failed to get dispute: %v", err)
+			msg := c.messages["failedToGetDispute"]
+			if errors.Is(err, database.ErrDisputeNotFound) {
+				msg = c.messages["operationNotDisputed"]
+			}
+			return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+		}
+		msg := c.disputeThread(dispute)
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message(), ParseMode: tg.ModeHTML})
+	}
+
+	dispute, err = c.db.DisputeOperation(ctx, chatID, operationID, userID, reason)
+	if err != nil {
+		log.Errorf("failed to dispute operation %d: %v", operationID, err)
+
+		var msg string
+		switch {
+		case errors.Is(err, database.ErrOperationNotFound):
+			msg = c.messages["operationNotFound"]
+		case errors.Is(err, database.ErrNotParty):
+			msg = c.messages["onlyPartiesCanDispute"]
+		case errors.Is(err, database.ErrAlreadyReverted):
+			msg = c.messages["operationAlreadyReverted"]
+		default:
+			msg = c.messages["failedToDisputeOperation"]
+		}
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	msg := fmt.Sprintf(c.messages["operationDisputed"], operationID, partyMentions(dispute.Parties, userID))
+	msg += c.disputeThread(dispute)
+	return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message(), ParseMode: tg.ModeHTML})
+}
+
+func (c Core) resolveCommand(tgCtx tg.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	chatID := tgCtx.Chat().ID
+	userID := int(tgCtx.Sender().ID)
+
+	operationID, comment, err := parseDisputePayload(tgCtx.Message().Payload)
+	if err != nil {
+		log.Errorf("failed to parse resolve payload: %v", err)
+		msg := c.messages["failedToParseOperationID"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	isAdmin, err := c.isChatAdmin(tgCtx)
+	if err != nil {
+		log.Errorf("failed to check if user %d is admin: %v", userID, err)
+		msg := c.messages["failedToResolveDispute"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+	if !isAdmin {
+		msg := c.messages["onlyAdminCanResolve"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	dispute, err := c.db.ResolveDispute(ctx, chatID, operationID, userID, database.ResolutionAdmin, comment)
+	if err != nil {
+		log.Errorf("failed to resolve dispute on operation %d: %v", operationID, err)
+		msg := c.messages["failedToResolveDispute"]
+		if errors.Is(err, database.ErrDisputeNotFound) {
+			msg = c.messages["operationNotDisputed"]
+		}
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	msg := fmt.Sprintf(c.messages["disputeResolved"], operationID, partyMentions(dispute.Parties, userID))
+	msg += c.disputeThread(dispute)
+	return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message(), ParseMode: tg.ModeHTML})
+}
+
+// isChatAdmin tells whether sender is admin of chat, in private chat user is the only member and admin
+func (c Core) isChatAdmin(tgCtx tg.Context) (bool, error) {
+	if tgCtx.Chat().Type == tg.ChatPrivate {
+		return true, nil
+	}
+	member, err := c.tg.ChatMemberOf(tgCtx.Chat(), tgCtx.Sender())
+	if err != nil {
+		return false, err
+	}
+	return member.Role == tg.Creator || member.Role == tg.Administrator, nil
+}
+
+func parseDisputePayload(payload string) (int64, string, error) {
+	match := reDisputePayload.FindStringSubmatch(strings.TrimSpace(payload))
+	if len(match) < 3 {
+		return 0, "", fmt.Errorf("invalid payload: %d of 3 matches", len(match))
+	}
+	operationID, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return 0, "", err
+	}
+	if operationID <= 0 {
+		return 0, "", fmt.Errorf("operation id must be positive: %d", operationID)
+	}
+	return operationID, match[2], nil
+}
+
+// partyMentions mentions parties of operation except the user and virtual members, who can't be notified.
+// Users without username are mentioned by link with their ID.
+func partyMentions(parties []database.User, exceptID int) string {
+	var mentions []string
+	for _, party := range parties {
+		if party.ID == exceptID || party.IsVirtual {
+			continue
+		}
+		if party.Name == "" {
+			mentions = append(mentions,
+				fmt.Sprintf(`<a href="tg://user?id=%d">%s</a>`, party.ID, html.EscapeString(party.DisplayName)))
+			continue
+		}
+		mentions = append(mentions, "@"+html.EscapeString(party.Name))
+	}
+	return strings.Join(mentions, ", ")
+}
+
+// disputeThread shows state of dispute and its comments
+func (c Core) disputeThread(dispute database.Dispute) string {
+	const rowTemplate = "<b>@%s</b>: %s\n"
+
+	var msg string
+	switch dispute.Resolution {
+	case database.ResolutionReverted:
+		msg = fmt.Sprintf(c.messages["disputeThreadReverted"], dispute.OperationID)
+	case database.ResolutionEdited:
+		msg = fmt.Sprintf(c.messages["disputeThreadEdited"], dispute.OperationID)
+	case database.ResolutionAdmin:
+		msg = fmt.Sprintf(c.messages["disputeThreadResolved"], dispute.OperationID)
+	default:
+		msg = fmt.Sprintf(c.messages["disputeThreadOpen"], dispute.OperationID)
+	}
+
+	for _, comment := range dispute.Comments {
+		msg += fmt.Sprintf(rowTemplate, html.EscapeString(comment.AuthorName), html.EscapeString(comment.Text))
+	}
+	return msg
+}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"moneyjar/pkg/database"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseDisputePayload(t *testing.T) {
+	tests := []struct {
+		name        string
+		payload     string
+		operationID int64
+		reason      string
+		wantErr     bool
+	}{
+		{name: "id only", payload: "42", operationID: 42},
+		{name: "id with hash", payload: "#42 не было меня", operationID: 42, reason: "не было меня"},
+		{name: "multiline reason", payload: "7 сумма не та\nбыло 50 ", operationID: 7, reason: "сумма не та\nбыло 50"},
+		{name: "no id", payload: "не было меня", wantErr: true},
+		{name: "zero id", payload: "0 ошибка", wantErr: true},
+		{name: "empty", payload: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			operationID, reason, err := parseDisputePayload(tt.payload)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.operationID, operationID)
+			assert.Equal(t, tt.reason, reason)
+		})
+	}
+}
+
+func Test_partyMentions(t *testing.T) {
+	parties := []database.User{
+		{ID: -1, Name: "Вася", IsVirtual: true},
+		{ID: 1, Name: "a"},
+		{ID: 2, Name: "b"},
+		{ID: 3, DisplayName: "Иван <И>"},
+	}
+	want := `@b, <a href="tg://user?id=3">Иван &lt;И&gt;</a>`
+	assert.Equal(t, want, partyMentions(parties, 1))
+}
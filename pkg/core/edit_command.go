@@ -0,0 +1,137 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"moneyjar/pkg/database"
+	"regexp"
+
+	log "github.com/sirupsen/logrus"
+	tg "gopkg.in/telebot.v3"
+)
+
+// reEditPayload matches operation ID and new debt in the same syntax as /debt
+var reEditPayload = regexp.MustCompile(`(?s)^#?(\d+)\s+(\S.*)$`)
+
+func (c Core) editCommand(tgCtx tg.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	match := reEditPayload.FindStringSubmatch(mentionsByID(tgCtx.Message()))
+	if len(match) < 3 {
+		msg := c.messages["editSyntax"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	operationID, err := parseOperationID(match[1])
+	if err != nil {
+		log.Errorf("failed to parse operation id: %v", err)
+		msg := c.messages["failedToParseOperationID"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	return c.editOperation(ctx, tgCtx, operationID, match[2])
+}
+
+// editOperation replaces debt of expense operation with debt parsed from payload and replies with
+// updated balances. Only author can edit operation.
+func (c Core) editOperation(ctx context.Context, tgCtx tg.Context, operationID int64, payload string) error {
+	chatID := tgCtx.Chat().ID
+	userID := int(tgCtx.Sender().ID)
+
+	logs, err := c.db.GetOperation(ctx, chatID, operationID)
+	if err != nil {
+		log.Errorf("failed to get operation: %v", err)
+		msg := c.messages["failedToEditOperation"]
+		if errors.Is(err, database.ErrOperationNotFound) {
+			msg = c.messages["operationNotFound"]
+		}
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	if logs[0].AuthorID != userID {
+		msg := c.messages["onlyAuthorCanEdit"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+	for _, l := range logs {
+		if l.Kind != database.KindExpense {
+			msg := c.messages["onlyDebtsCanBeEdited"]
+			return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+		}
+	}
+
+	// Edit changes balances right away, so debtors could not confirm it
+	confirm, err := c.confirmDebts(ctx, chatID)
+	if err != nil {
+		log.Errorf("failed to get ledger: %v", err)
+		msg := c.messages["failedToGetLedger"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+	if confirm {
+		msg := c.messages["editNeedsConfirmation"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	debt, err := c.parsePayload(ctx, tgCtx, payload)
+	if err != nil {
+		log.Errorf("failed to parse payload: %v", err)
+		return c.sendParseError(tgCtx, err, payload)
+	}
+	if debt.amount.IsZero() {
+		msg := c.messages["editToZero"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+	// Backdated debt stays backdated unless another date is set
+	if debt.date.IsZero() && backdatedMarker(logs[0]) != "" {
+		debt.date = logs[0].EffectiveDate
+	}
+
+	base, err := c.ledgerCurrency(ctx, chatID)
+	if err != nil {
+		log.Errorf("failed to get ledger currency: %v", err)
+		msg := c.messages["failedToGetLedger"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	baseAmount, quote, err := c.convertToBase(ctx, chatID, debt.currency, debt.amount, base, debt.date)
+	if err != nil {
+		log.Errorf("failed to convert currency to %s: %v", base.Code, err)
+		msg := c.messages["failedToConvertCurrency"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	op := &database.Operation{
+		ID:       operationID,
+		ChatID:   chatID,
+		AuthorID: userID,
+		Kind:     database.KindExpense,
+		Comment:  debt.comment,
+
+		Currency:      debt.currency.Code,
+		ExchangeRate:  quote.Rate,
+		RateSource:    quote.Source,
+		EffectiveDate: debt.date,
+	}
+	accounts, err := c.db.EditOperation(ctx, op, debt.transfers(baseAmount))
+	if err != nil {
+		log.Errorf("failed to edit operation %d: %v", operationID, err)
+
+		var msg string
+		switch {
+		case errors.Is(err, database.ErrAlreadyReverted):
+			msg = c.messages["operationAlreadyReverted"]
+		case errors.Is(err, database.ErrOperationNotFound):
+			msg = c.messages["operationNotFound"]
+		default:
+			msg = c.messages["failedToEditOperation"]
+		}
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	var msg = fmt.Sprintf("Операция #%d изменена (%s): \n", operationID, debt.describe(quote))
+	msg += generateBalanceMessage(accounts, c.userDisplay(ctx, userID, base))
+	msg += c.pendingUsersNote(accounts)
+	msg += c.rateWarning(quote)
+	return c.replyOperation(ctx, tgCtx, operationID, msg)
+}
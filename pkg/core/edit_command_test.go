@@ -0,0 +1,30 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_reEditPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    []string
+	}{
+		{name: "id and debt", payload: "42 150 usd @a @b такси", want: []string{"42", "150 usd @a @b такси"}},
+		{name: "id with hash", payload: "#7 вчера 10$ @a", want: []string{"7", "вчера 10$ @a"}},
+		{name: "id only", payload: "42", want: nil},
+		{name: "no debt", payload: "#42  ", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match := reEditPayload.FindStringSubmatch(tt.payload)
+			if tt.want == nil {
+				assert.Nil(t, match)
+				return
+			}
+			assert.Equal(t, tt.want, match[1:])
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package core
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	// maxExpressionLength and maxExpressionDepth keep evaluation of user input cheap
+	maxExpressionLength = 100
+	maxExpressionDepth  = 10
+)
+
+// evalExpression evaluates arithmetic expression with numbers, + - * / and parentheses, e.g. "12.5*3+4".
+// Numbers are parsed with parseNumber, nothing else like variables or functions is supported.
+// Result is exact, so "100/3*3" is 100.
+func evalExpression(s string) (*big.Rat, error) {
+	if len(s) > maxExpressionLength {
+		return nil, fmt.Errorf("expression is longer than %d characters", maxExpressionLength)
+	}
+
+	p := &expressionParser{input: s}
+	result, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.input) {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.input[p.pos], p.pos+1)
+	}
+	return result, nil
+}
+
+// isExpression reports if amount is an expression rather than a plain number
+func isExpression(s string) bool {
+	for i, r := range s {
+		switch r {
+		case '+', '*', '/', '(', ')':
+			return true
+		case '-':
+			if i > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// expressionParser is a recursive descent parser of grammar:
+//
+//	expression = term {("+" | "-") term}
+//	term       = factor {("*" | "/") factor}
+//	factor     = ("+" | "-") factor | number | "(" expression ")"
+type expressionParser struct {
+	input string
+	pos   int
+	depth int
+}
+
+func (p *expressionParser) expression() (*big.Rat, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxExpressionDepth {
+		return nil, fmt.Errorf("expression is nested deeper than %d levels", maxExpressionDepth)
+	}
+
+	result, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for p.skipSpaces(); p.pos < len(p.input); p.skipSpaces() {
+		op := p.input[p.pos]
+		if op != '+' && op != '-' {
+			break
+		}
+		p.pos++
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		if op == '+' {
+			result.Add(result, right)
+		} else {
+			result.Sub(result, right)
+		}
+	}
+	return result, nil
+}
+
+func (p *expressionParser) term() (*big.Rat, error) {
+	result, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	for p.skipSpaces(); p.pos < len(p.input); p.skipSpaces() {
+		op := p.input[p.pos]
+		if op != '*' && op != '/' {
+			break
+		}
+		p.pos++
+		right, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		if op == '*' {
+			result.Mul(result, right)
+		} else {
+			if right.Sign() == 0 {
+				return nil, fmt.Errorf("division by zero at position %d", p.pos)
+			}
+			result.Quo(result, right)
+		}
+	}
+	return result, nil
+}
+
+func (p *expressionParser) factor() (*big.Rat, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.input) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+
+	switch p.input[p.pos] {
+	case '+', '-':
+		sign := p.input[p.pos]
+		p.pos++
+		p.depth++
+		defer func() { p.depth-- }()
+		if p.depth > maxExpressionDepth {
+			return nil, fmt.Errorf("expression is nested deeper than %d levels", maxExpressionDepth)
+		}
+		value, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		if sign == '-' {
+			value.Neg(value)
+		}
+		return value, nil
+	case '(':
+		p.pos++
+		value, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.input) || p.input[p.pos] != ')' {
+			return nil, fmt.Errorf("missing ) at position %d", p.pos+1)
+		}
+		p.pos++
+		return value, nil
+	}
+
+	start := p.pos
+	p.scanNumber()
+	if start == p.pos {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.input[p.pos], p.pos+1)
+	}
+	value, err := parseNumber(p.input[start:p.pos])
+	if err != nil {
+		return nil, fmt.Errorf("bad number at position %d: %w", start+1, err)
+	}
+	return value, nil
+}
+
+// skipSpaces moves position over spaces around operators and parentheses
+func (p *expressionParser) skipSpaces() {
+	for p.pos < len(p.input) && p.input[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// scanNumber moves position to the end of number, which can have locale separators and thousand suffix
+func (p *expressionParser) scanNumber() {
+	for p.pos < len(p.input) {
+		ch := p.input[p.pos]
+		switch {
+		case ch >= '0' && ch <= '9', ch == '.', ch == ',':
+			p.pos++
+		case ch == ' ' && p.pos+1 < len(p.input) && p.input[p.pos+1] >= '0' && p.input[p.pos+1] <= '9':
+			p.pos++
+		default:
+			for _, suffix := range thousandSuffixes {
+				if strings.HasPrefix(p.input[p.pos:], suffix) {
+					p.pos += len(suffix)
+					return
+				}
+			}
+			return
+		}
+	}
+}
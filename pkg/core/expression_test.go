@@ -0,0 +1,66 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_evalExpression(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    float64
+		wantErr bool
+	}{
+		{name: "number", input: "12.5", want: 12.5},
+		{name: "negative number", input: "-100", want: -100},
+		{name: "precedence", input: "12.5*3+4", want: 41.5},
+		{name: "parentheses", input: "(10+20)/4", want: 7.5},
+		{name: "left associative", input: "10-2-3", want: 5},
+		{name: "unary minus in parentheses", input: "2*(-3+1)", want: -4},
+		{name: "nested parentheses", input: "((1+2)*(3+4))", want: 21},
+		{name: "division by zero", input: "1/0", wantErr: true},
+		{name: "division by zero expression", input: "1/(2-2)", wantErr: true},
+		{name: "missing parenthesis", input: "(1+2", wantErr: true},
+		{name: "extra parenthesis", input: "1+2)", wantErr: true},
+		{name: "trailing operator", input: "1+", wantErr: true},
+		{name: "double operator", input: "1*/2", wantErr: true},
+		{name: "bad number", input: "1.2.3", wantErr: true},
+		{name: "letters", input: "1+x", wantErr: true},
+		{name: "empty", input: "", wantErr: true},
+		{name: "too deep", input: strings.Repeat("(", 20) + "1" + strings.Repeat(")", 20), wantErr: true},
+		{name: "too long", input: strings.Repeat("1+", 60) + "1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := evalExpression(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			value, _ := got.Float64()
+			assert.InDelta(t, tt.want, value, 1e-9)
+		})
+	}
+}
+
+func Test_evalExpression_exact(t *testing.T) {
+	got, err := evalExpression("100/3*3")
+	assert.NoError(t, err)
+	assert.Equal(t, "100", got.RatString())
+
+	got, err = evalExpression("0.1+0.2")
+	assert.NoError(t, err)
+	assert.Equal(t, "3/10", got.RatString())
+}
+
+func Test_isExpression(t *testing.T) {
+	assert.False(t, isExpression("12.5"))
+	assert.False(t, isExpression("-12.5"))
+	assert.True(t, isExpression("10-2"))
+	assert.True(t, isExpression("(10)"))
+	assert.True(t, isExpression("2*3"))
+}
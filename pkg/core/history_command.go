@@ -3,6 +3,7 @@ package core
 import (
 	"context"
 	"fmt"
+	"moneyjar/pkg/database"
 	"strconv"
 
 	log "github.com/sirupsen/logrus"
@@ -14,6 +15,7 @@ func (c Core) historyCommand(tgCtx tg.Context) error {
 	defer cancel()
 
 	var (
+		chatID  = tgCtx.Chat().ID
 		userID  = int(tgCtx.Sender().ID)
 		payload = tgCtx.Message().Payload
 		page    = 1
@@ -29,23 +31,106 @@ func (c Core) historyCommand(tgCtx tg.Context) error {
 		}
 	}
 
-	logs, err := c.db.GetTransactionsForUser(ctx, userID, page)
+	base, err := c.ledgerCurrency(ctx, chatID)
+	if err != nil {
+		log.Errorf("failed to get ledger currency: %v", err)
+		msg := c.messages["failedToGetLedger"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	logs, err := c.db.GetTransactionsForUser(ctx, chatID, userID, page)
 	if err != nil {
 		log.Errorf("failed to get log for user %d: %v", userID, err)
 		msg := c.messages["failedToGetHistory"]
 		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
 	}
+	d := c.userDisplay(ctx, userID, base)
 	msg := fmt.Sprintf("История, страница %d: \n", page)
 
 	for i, l := range logs {
 		msgLine := fmt.Sprintf(
-			"%d) @%s -> @%s: %.2f$; %s\n",
+			"%d) [#%d] %s@%s -> @%s: %s%s; %s%s%s%s\n",
 			i+1,
+			l.OperationID,
+			kindMarker(l.Kind),
 			l.FromUserName,
 			l.ToUserName,
-			float64(l.BalanceChange)/100.0,
-			l.Comment)
+			c.formatLogAmount(l, d),
+			backdatedMarker(l),
+			l.Comment,
+			revertedMarker(l),
+			editedMarker(l),
+			disputedMarker(l))
 		msg += msgLine
 	}
 	return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
 }
+
+// kindMarker prefixes history lines so repayments can be told apart from expenses
+func kindMarker(kind database.Kind) string {
+	switch kind {
+	case database.KindSettlement:
+		return "💸 "
+	case database.KindNetting:
+		return "🔄 "
+	case database.KindRevert:
+		return "↩️ "
+	default:
+		return ""
+	}
+}
+
+// formatLogAmount shows amount as it was entered together with converted value, e.g. "500 GEL (≈185.20$ @ 0.3704)".
+// Rate of operation is shown only if amount is displayed in ledger base currency, as it is rate to base.
+func (c Core) formatLogAmount(l database.Log, d display) string {
+	amount := d.format(l.BalanceChange)
+	if l.Currency == "" || l.Currency == d.base.Code {
+		return amount
+	}
+
+	originalAmount := l.OriginalAmount
+	if cur, ok := c.currencies.Get(l.Currency); ok {
+		if rescaled, err := originalAmount.Rescale(cur.Decimals); err == nil {
+			originalAmount = rescaled
+		}
+	}
+	originalAmount = originalAmount.Reduce()
+	if d.cur.Code != d.base.Code {
+		return fmt.Sprintf("%s %s (%s)", originalAmount, l.Currency, amount)
+	}
+	var pinnedMark string
+	if l.RateSource == pinnedRateSource {
+		pinnedMark = " 📌"
+	}
+	return fmt.Sprintf("%s %s (≈%s @ %.4f%s)", originalAmount, l.Currency, amount, l.ExchangeRate, pinnedMark)
+}
+
+// backdatedMarker shows date of expense if it was recorded later
+func backdatedMarker(l database.Log) string {
+	if l.EffectiveDate.IsZero() || l.EffectiveDate.Format(dateLayout) == l.TS.Format(dateLayout) {
+		return ""
+	}
+	return " за " + l.EffectiveDate.Format(dateLayout)
+}
+
+func revertedMarker(l database.Log) string {
+	if l.Reverted {
+		return " (отменено)"
+	}
+	return ""
+}
+
+func editedMarker(l database.Log) string {
+	if l.Edited {
+		return " (изменено)"
+	}
+	return ""
+}
+
+// disputedMarker warns that someone objects to operation, /dispute with its ID shows why
+func disputedMarker(l database.Log) string {
+	if l.Disputed {
+		return " ⚠️ оспорено"
+	}
+	return ""
+}
@@ -0,0 +1,179 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"moneyjar/pkg/database"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	tg "gopkg.in/telebot.v3"
+)
+
+// reMemberName matches names which can be mentioned in /debt like Telegram usernames
+var reMemberName = regexp.MustCompile(`^[\p{L}\d_]{1,32}$`)
+
+// addMemberCommand adds member who is not on Telegram, e.g. /addmember Вася
+func (c Core) addMemberCommand(tgCtx tg.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	chatID := tgCtx.Chat().ID
+	name, ok := parseMemberName(tgCtx.Message().Payload)
+	if !ok {
+		msg := c.messages["badMemberName"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	ledger := database.Ledger{ChatID: chatID, BaseCurrency: c.defaultBase.Code, BaseDecimals: c.defaultBase.Decimals}
+	if err := c.db.CreateLedger(ctx, ledger); err != nil {
+		log.Errorf("failed to create ledger: %v", err)
+		msg := c.messages["failedToAddUser"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	user, claimed, err := c.db.CreateVirtualUser(ctx, chatID, name, int(tgCtx.Sender().ID))
+	if err != nil {
+		log.Errorf("failed to create virtual user: %v", err)
+		msg := c.messages["failedToAddUser"]
+		if errors.Is(err, database.ErrUserExists) {
+			msg = fmt.Sprintf(c.messages["memberExists"], name)
+		}
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	msg := fmt.Sprintf(c.messages["virtualMemberAdded"], user.Name)
+	if len(claimed) == 0 {
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	base, err := c.ledgerCurrency(ctx, chatID)
+	if err != nil {
+		log.Errorf("failed to get ledger currency: %v", err)
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+	msg += "\n" + c.messages["pendingDebtsClaimed"] + "\n"
+	msg += generateBalanceMessage(claimed, c.userDisplay(ctx, int(tgCtx.Sender().ID), base))
+	return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message(), ParseMode: tg.ModeHTML})
+}
+
+// linkCommand links virtual member to Telegram account of sender or of mentioned user, debts and history of
+// member move to that account. Member can be linked to oneself by user who added it, chat admins can link any
+// member to anyone, e.g. /link Вася @vasya.
+func (c Core) linkCommand(tgCtx tg.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	chatID := tgCtx.Chat().ID
+	senderID := int(tgCtx.Sender().ID)
+	name, mention, ok := parseLinkPayload(mentionsByID(tgCtx.Message()))
+	if !ok {
+		msg := c.messages["badMemberName"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	member, err := c.db.GetVirtualUser(ctx, chatID, name)
+	if err != nil {
+		log.Errorf("failed to get virtual user: %v", err)
+		msg := c.messages["failedToLinkMember"]
+		if errors.Is(err, database.ErrVirtualUserNotFound) {
+			msg = fmt.Sprintf(c.messages["virtualMemberNotFound"], name)
+		}
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	userID, userName := senderID, senderName(tgCtx.Sender())
+	if strings.EqualFold(mention, tgCtx.Sender().Username) {
+		mention = ""
+	}
+	if mention != "" {
+		var account database.Account
+		if account, err = c.mentionToAccount(ctx, chatID, senderID, mention); err != nil {
+			log.Errorf("failed to get user %s: %v", mention, err)
+			msg := c.messages["failedToLinkMember"]
+			if errors.Is(err, database.ErrUserNotRegistered) {
+				msg = c.messages["userNotRegistered"]
+			}
+			return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+		}
+		if account.ToUser < 0 {
+			msg := c.messages["linkToVirtualMember"]
+			return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+		}
+		userID, userName = account.ToUser, account.ToUserName
+	}
+
+	// Admins are asked only if the sender can't link member by oneself, it takes a request to Telegram
+	if !canLinkMember(member, senderID, userID) {
+		var isAdmin bool
+		if isAdmin, err = c.isChatAdmin(tgCtx); err != nil {
+			log.Errorf("failed to check if user %d is admin: %v", senderID, err)
+			msg := c.messages["failedToLinkMember"]
+			return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+		}
+		if !isAdmin {
+			msg := fmt.Sprintf(c.messages["onlyCreatorOrAdminCanLink"], name)
+			return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+		}
+	}
+
+	if err = c.db.LinkVirtualUser(ctx, chatID, name, userID); err != nil {
+		log.Errorf("failed to link virtual user: %v", err)
+		msg := c.messages["failedToLinkMember"]
+		switch {
+		case errors.Is(err, database.ErrVirtualUserNotFound):
+			msg = fmt.Sprintf(c.messages["virtualMemberNotFound"], name)
+		case errors.Is(err, database.ErrUserNotRegistered) && userID != senderID:
+			msg = c.messages["userNotRegistered"]
+		case errors.Is(err, database.ErrUserNotRegistered):
+			msg = c.messages["authorNotRegistered"]
+		}
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	base, err := c.ledgerCurrency(ctx, chatID)
+	if err != nil {
+		log.Errorf("failed to get ledger currency: %v", err)
+		msg := c.messages["failedToGetLedger"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	accounts, err := c.db.GetAccountsWithUser(ctx, chatID, userID)
+	if err != nil {
+		log.Errorf("failed to get accounts: %v", err)
+		msg := c.messages["failedToGetAccounts"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	msg := fmt.Sprintf(c.messages["virtualMemberLinked"], name, html.EscapeString(userName)) + "\n"
+	msg += generateBalanceMessage(accounts, c.userDisplay(ctx, senderID, base))
+	return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message(), ParseMode: tg.ModeHTML})
+}
+
+// canLinkMember tells whether sender can link virtual member to user without approval of chat admin.
+// Only user who added the member can link it and only to oneself.
+func canLinkMember(member database.User, senderID, userID int) bool {
+	return member.CreatedBy != 0 && member.CreatedBy == senderID && userID == senderID
+}
+
+// parseLinkPayload returns name of virtual member and optional mention of user to link it to
+func parseLinkPayload(payload string) (name, mention string, ok bool) {
+	fields := strings.Fields(payload)
+	switch {
+	case len(fields) == 2 && len(fields[1]) > 1 && strings.HasPrefix(fields[1], "@"):
+		mention = fields[1][1:]
+	case len(fields) != 1:
+		return "", "", false
+	}
+	name, ok = parseMemberName(fields[0])
+	return name, mention, ok
+}
+
+// parseMemberName returns name of virtual member, it can be written as mention
+func parseMemberName(payload string) (string, bool) {
+	name := strings.TrimPrefix(strings.TrimSpace(payload), "@")
+	return name, reMemberName.MatchString(name)
+}
@@ -0,0 +1,77 @@
+package core
+
+import (
+	"moneyjar/pkg/database"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseMemberName(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    string
+		wantOk  bool
+	}{
+		{payload: "Вася", want: "Вася", wantOk: true},
+		{payload: " @bob_2 ", want: "bob_2", wantOk: true},
+		{payload: "", wantOk: false},
+		{payload: "Вася Пупкин", wantOk: false},
+		{payload: "bob!", wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.payload, func(t *testing.T) {
+			name, ok := parseMemberName(tt.payload)
+			assert.Equal(t, tt.wantOk, ok)
+			if tt.wantOk {
+				assert.Equal(t, tt.want, name)
+			}
+		})
+	}
+}
+
+func Test_parseLinkPayload(t *testing.T) {
+	tests := []struct {
+		payload     string
+		wantName    string
+		wantMention string
+		wantOk      bool
+	}{
+		{payload: "Вася", wantName: "Вася", wantOk: true},
+		{payload: "@Вася @vasya", wantName: "Вася", wantMention: "vasya", wantOk: true},
+		{payload: "Вася vasya", wantOk: false},
+		{payload: "Вася @", wantOk: false},
+		{payload: "Вася @a @b", wantOk: false},
+		{payload: "", wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.payload, func(t *testing.T) {
+			name, mention, ok := parseLinkPayload(tt.payload)
+			assert.Equal(t, tt.wantOk, ok)
+			if tt.wantOk {
+				assert.Equal(t, tt.wantName, name)
+				assert.Equal(t, tt.wantMention, mention)
+			}
+		})
+	}
+}
+
+func Test_canLinkMember(t *testing.T) {
+	tests := []struct {
+		name     string
+		member   database.User
+		senderID int
+		userID   int
+		want     bool
+	}{
+		{name: "creator links to oneself", member: database.User{ID: -1, CreatedBy: 1}, senderID: 1, userID: 1, want: true},
+		{name: "other user links to oneself", member: database.User{ID: -1, CreatedBy: 1}, senderID: 2, userID: 2},
+		{name: "creator links to other user", member: database.User{ID: -1, CreatedBy: 1}, senderID: 1, userID: 2},
+		{name: "creator is unknown", member: database.User{ID: -1}, senderID: 1, userID: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, canLinkMember(tt.member, tt.senderID, tt.userID))
+		})
+	}
+}
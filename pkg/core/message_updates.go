@@ -0,0 +1,109 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"moneyjar/pkg/database"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	tg "gopkg.in/telebot.v3"
+)
+
+// undoWords are replies to message with command or to reply of bot about it which revert the operation.
+// Bot API doesn't notify bots about deleted messages, so reply is the way to revert operation from its message.
+// Bots in privacy mode don't get replies to messages of other users, but they always get replies to own ones.
+var undoWords = []string{"undo", "отмена", "отменить"}
+
+// editedMessage applies edit of message with /debt to operation written by the message
+func (c Core) editedMessage(tgCtx tg.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	m := tgCtx.Message()
+	match := reCommand.FindStringSubmatch(m.Text)
+	if match == nil || match[1] != "/debt" || (match[3] != "" && !strings.EqualFold(match[3], c.tg.Me.Username)) {
+		return nil
+	}
+	// Telebot parses payload of new messages only
+	m.Payload = match[5]
+
+	operationID, err := c.db.GetOperationByMessage(ctx, tgCtx.Chat().ID, m.ID)
+	if errors.Is(err, database.ErrOperationNotFound) {
+		// Debt could be rejected or wait for confirmation, edit of such message changes nothing
+		log.Debugf("edited message %d has no operation", m.ID)
+		return nil
+	}
+	if err != nil {
+		log.Errorf("failed to get operation of message: %v", err)
+		msg := c.messages["failedToEditOperation"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: m})
+	}
+
+	return c.editOperation(ctx, tgCtx, operationID, mentionsByID(m))
+}
+
+// replyOperation replies with HTML message about operation and remembers the reply, so it can be replied
+// with one of undoWords too
+func (c Core) replyOperation(ctx context.Context, tgCtx tg.Context, operationID int64, msg string) error {
+	sent, err := c.tg.Send(tgCtx.Recipient(), msg, &tg.SendOptions{ReplyTo: tgCtx.Message(), ParseMode: tg.ModeHTML})
+	if err != nil {
+		return fmt.Errorf("failed to reply about operation %d: %v", operationID, err)
+	}
+	if err = c.db.AddOperationMessage(ctx, tgCtx.Chat().ID, sent.ID, operationID); err != nil {
+		// Operation is written anyway, it just can't be reverted by reply to this message
+		log.Errorf("failed to save reply about operation: %v", err)
+	}
+	return nil
+}
+
+// textMessage reverts operation of message replied with one of undoWords
+func (c Core) textMessage(tgCtx tg.Context) error {
+	m := tgCtx.Message()
+	if m.ReplyTo == nil || !isUndoReply(m.Text) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	chatID := tgCtx.Chat().ID
+	operationID, err := c.db.GetOperationByMessage(ctx, chatID, m.ReplyTo.ID)
+	if errors.Is(err, database.ErrOperationNotFound) {
+		log.Debugf("replied message %d has no operation", m.ReplyTo.ID)
+		return nil
+	}
+	if err != nil {
+		log.Errorf("failed to get operation of message: %v", err)
+		msg := c.messages["failedToRevertOperation"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: m})
+	}
+
+	logs, err := c.db.GetOperation(ctx, chatID, operationID)
+	if err != nil {
+		log.Errorf("failed to get operation: %v", err)
+		msg := c.messages["failedToRevertOperation"]
+		if errors.Is(err, database.ErrOperationNotFound) {
+			msg = c.messages["operationNotFound"]
+		}
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: m})
+	}
+
+	if logs[0].AuthorID != int(tgCtx.Sender().ID) {
+		msg := c.messages["onlyAuthorCanRevert"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: m})
+	}
+
+	return c.revertOperation(ctx, tgCtx, operationID)
+}
+
+func isUndoReply(text string) bool {
+	text = strings.ToLower(strings.TrimSpace(text))
+	for _, word := range undoWords {
+		if text == word {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_isUndoReply(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{text: "undo", want: true},
+		{text: " Отмена\n", want: true},
+		{text: "ОТМЕНИТЬ", want: true},
+		{text: "отмена, я ошибся", want: false},
+		{text: "/undo", want: false},
+		{text: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			assert.Equal(t, tt.want, isUndoReply(tt.text))
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"moneyjar/pkg/database"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	tg "gopkg.in/telebot.v3"
+)
+
+// pinnedRateSource is source of rates pinned by chat members
+const pinnedRateSource = "pinned"
+
+// rateUnpinArg removes pinned rate
+const rateUnpinArg = "off"
+
+var (
+	reRatePayload = regexp.MustCompile(`^(\S+) +(\S+)(?: +(\S+))?$`)
+	reDaysExpiry  = regexp.MustCompile(`^(\d+)d$`)
+)
+
+func (c Core) rateCommand(tgCtx tg.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	chatID := tgCtx.Chat().ID
+	payload := strings.TrimSpace(tgCtx.Message().Payload)
+
+	base, err := c.ledgerCurrency(ctx, chatID)
+	if err != nil {
+		log.Errorf("failed to get ledger currency: %v", err)
+		msg := c.messages["failedToGetLedger"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	if payload == "" {
+		return c.sendPinnedRates(ctx, tgCtx, base.Code)
+	}
+
+	match := reRatePayload.FindStringSubmatch(payload)
+	if len(match) < 4 {
+		msg := c.messages["failedToParsePayload"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	cur, err := c.parseCurrency(match[1])
+	if err != nil {
+		msg := fmt.Sprintf(c.messages["unknownCurrency"], strings.Join(c.currencies.Codes(), ", "))
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+	if cur.Code == base.Code {
+		msg := fmt.Sprintf(c.messages["ledgerBaseCurrency"], base.Code)
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	if strings.ToLower(match[2]) == rateUnpinArg {
+		err = c.db.UnpinRate(ctx, chatID, cur.Code)
+		switch {
+		case errors.Is(err, database.ErrPinnedRateNotFound):
+			msg := c.messages["pinnedRateNotFound"]
+			return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+		case err != nil:
+			log.Errorf("failed to unpin rate: %v", err)
+			msg := c.messages["failedToPinRate"]
+			return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+		}
+		msg := fmt.Sprintf(c.messages["rateUnpinned"], cur.Code)
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	rate, err := strconv.ParseFloat(strings.ReplaceAll(match[2], ",", "."), 64)
+	if err != nil || rate <= 0 {
+		log.Errorf("failed to parse rate %q: %v", match[2], err)
+		msg := c.messages["failedToParsePayload"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	pinned := database.PinnedRate{
+		ChatID:       chatID,
+		Currency:     cur.Code,
+		BaseCurrency: base.Code,
+		Rate:         rate,
+		AuthorID:     int(tgCtx.Sender().ID),
+	}
+	if match[3] != "" {
+		expiresAt, err := parseRateExpiry(match[3], time.Now())
+		if err != nil {
+			log.Errorf("failed to parse expiry: %v", err)
+			msg := c.messages["failedToParsePayload"]
+			return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+		}
+		pinned.ExpiresAt = sql.NullTime{Time: expiresAt, Valid: true}
+	}
+
+	if err = c.db.PinRate(ctx, pinned); err != nil {
+		log.Errorf("failed to pin rate: %v", err)
+		msg := c.messages["failedToPinRate"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	msg := fmt.Sprintf(c.messages["ratePinned"], rate, cur.Code, base.Code)
+	if pinned.ExpiresAt.Valid {
+		msg += fmt.Sprintf(" до %s", pinned.ExpiresAt.Time.Format(timeLayout))
+	}
+	return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+}
+
+func (c Core) sendPinnedRates(ctx context.Context, tgCtx tg.Context, base string) error {
+	pinned, err := c.db.GetPinnedRates(ctx, tgCtx.Chat().ID)
+	if err != nil {
+		log.Errorf("failed to get pinned rates: %v", err)
+		msg := c.messages["failedToGetPinnedRates"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	var msg = "Закрепленные курсы: \n"
+	for _, p := range pinned {
+		if p.BaseCurrency != base {
+			continue
+		}
+		msg += fmt.Sprintf("1 %s = %.4f %s", p.BaseCurrency, p.Rate, p.Currency)
+		if p.ExpiresAt.Valid {
+			msg += fmt.Sprintf(", до %s", p.ExpiresAt.Time.Format(timeLayout))
+		}
+		msg += "\n"
+	}
+	msg += "\n/rate КОД КУРС [срок] чтобы закрепить курс, срок как 3d, 12h или дата"
+	return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message(), DisableNotification: true})
+}
+
+// parseRateExpiry parses expiry of pinned rate: duration like "12h", number of days like "3d" or date "2026-10-25",
+// rate of date expires at the end of that day
+func parseRateExpiry(s string, now time.Time) (time.Time, error) {
+	var expiresAt time.Time
+	if match := reDaysExpiry.FindStringSubmatch(s); len(match) == 2 {
+		days, err := strconv.Atoi(match[1])
+		if err != nil {
+			return time.Time{}, err
+		}
+		expiresAt = now.AddDate(0, 0, days)
+	} else if duration, err := time.ParseDuration(s); err == nil {
+		expiresAt = now.Add(duration)
+	} else {
+		date, err := time.ParseInLocation("2006-01-02", s, now.Location())
+		if err != nil {
+			return time.Time{}, fmt.Errorf("bad expiry %q", s)
+		}
+		expiresAt = date.AddDate(0, 0, 1)
+	}
+
+	if !expiresAt.After(now) {
+		return time.Time{}, fmt.Errorf("expiry %q is in the past", s)
+	}
+	return expiresAt, nil
+}
@@ -0,0 +1,38 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseRateExpiry(t *testing.T) {
+	now := time.Date(2026, 10, 18, 15, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		expiry  string
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "days", expiry: "3d", want: time.Date(2026, 10, 21, 15, 30, 0, 0, time.UTC)},
+		{name: "duration", expiry: "12h", want: time.Date(2026, 10, 19, 3, 30, 0, 0, time.UTC)},
+		{name: "date", expiry: "2026-10-25", want: time.Date(2026, 10, 26, 0, 0, 0, 0, time.UTC)},
+		{name: "today", expiry: "2026-10-18", want: time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+		{name: "past", expiry: "2026-10-17", wantErr: true},
+		{name: "zero", expiry: "0d", wantErr: true},
+		{name: "garbage", expiry: "soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRateExpiry(tt.expiry, now)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package core
+
+import (
+	"fmt"
+	"time"
+
+	tg "gopkg.in/telebot.v3"
+)
+
+func (c Core) ratesCommand(tgCtx tg.Context) error {
+	var msg = "Курсы в кэше: \n"
+
+	now := time.Now()
+	for _, quote := range c.rateCache.Quotes() {
+		var staleMark string
+		if now.After(quote.ExpiresAt) {
+			staleMark = " (устарел)"
+		}
+		msg += fmt.Sprintf(
+			"%s/%s: %.4f, %s, до %s%s\n",
+			quote.From,
+			quote.To,
+			quote.Rate,
+			quote.Source,
+			quote.ExpiresAt.Format(timeLayout),
+			staleMark)
+	}
+
+	stats := c.rateCache.Stats()
+	msg += fmt.Sprintf("\nПопаданий: %d, промахов: %d, из них устаревших: %d", stats.Hits, stats.Misses, stats.Stale)
+	return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message(), DisableNotification: true})
+}
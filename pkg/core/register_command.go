@@ -2,7 +2,9 @@ package core
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"moneyjar/pkg/database"
 
 	log "github.com/sirupsen/logrus"
 	tg "gopkg.in/telebot.v3"
@@ -12,14 +14,36 @@ func (c Core) registerCommand(tgCtx tg.Context) error {
 	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
 	defer cancel()
 
-	id := int(tgCtx.Sender().ID)
-	username := tgCtx.Sender().Username
+	chatID := tgCtx.Chat().ID
+	user := userFromTelegram(tgCtx.Sender())
 
-	if err := c.db.CreateUser(ctx, id, username); err != nil {
+	ledger := database.Ledger{ChatID: chatID, BaseCurrency: c.defaultBase.Code, BaseDecimals: c.defaultBase.Decimals}
+	if err := c.db.CreateLedger(ctx, ledger); err != nil {
+		log.Errorf("failed to create ledger: %v", err)
+		msg := c.messages["failedToAddUser"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	claimed, err := c.db.CreateUser(ctx, chatID, user)
+	if err != nil {
 		log.Errorf("failed to create user: %v", err)
 		msg := c.messages["failedToAddUser"]
+		if errors.Is(err, database.ErrUserExists) {
+			msg = fmt.Sprintf(c.messages["registerAsVirtualMember"], user.Name, user.Name)
+		}
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+	msg := fmt.Sprintf(c.messages["succesifullyAddedUser"], senderName(tgCtx.Sender()))
+	if len(claimed) == 0 {
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	base, err := c.ledgerCurrency(ctx, chatID)
+	if err != nil {
+		log.Errorf("failed to get ledger currency: %v", err)
 		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
 	}
-	msg := fmt.Sprintf(c.messages["succesifullyAddedUser"], tgCtx.Sender().Username)
-	return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	msg += "\n" + c.messages["pendingDebtsClaimed"] + "\n"
+	msg += generateBalanceMessage(claimed, c.userDisplay(ctx, user.ID, base))
+	return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message(), ParseMode: tg.ModeHTML})
 }
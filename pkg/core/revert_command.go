@@ -0,0 +1,118 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"moneyjar/pkg/database"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	tg "gopkg.in/telebot.v3"
+)
+
+func (c Core) undoCommand(tgCtx tg.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	chatID := tgCtx.Chat().ID
+	userID := int(tgCtx.Sender().ID)
+
+	operationID, err := c.db.GetLastOperationID(ctx, chatID, userID)
+	if err != nil {
+		log.Errorf("failed to get last operation: %v", err)
+		msg := c.messages["failedToRevertOperation"]
+		if errors.Is(err, database.ErrOperationNotFound) {
+			msg = c.messages["nothingToUndo"]
+		}
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	return c.revertOperation(ctx, tgCtx, operationID)
+}
+
+func (c Core) revertCommand(tgCtx tg.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	chatID := tgCtx.Chat().ID
+	userID := int(tgCtx.Sender().ID)
+
+	operationID, err := parseOperationID(tgCtx.Message().Payload)
+	if err != nil {
+		log.Errorf("failed to parse operation id: %v", err)
+		msg := c.messages["failedToParseOperationID"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	logs, err := c.db.GetOperation(ctx, chatID, operationID)
+	if err != nil {
+		log.Errorf("failed to get operation: %v", err)
+		msg := c.messages["failedToRevertOperation"]
+		if errors.Is(err, database.ErrOperationNotFound) {
+			msg = c.messages["operationNotFound"]
+		}
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	if logs[0].AuthorID != userID {
+		msg := c.messages["onlyAuthorCanRevert"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	return c.revertOperation(ctx, tgCtx, operationID)
+}
+
+// revertOperation writes compensating records for operation and replies with updated balances
+func (c Core) revertOperation(ctx context.Context, tgCtx tg.Context, operationID int64) error {
+	chatID := tgCtx.Chat().ID
+	base, err := c.ledgerCurrency(ctx, chatID)
+	if err != nil {
+		log.Errorf("failed to get ledger currency: %v", err)
+		msg := c.messages["failedToGetLedger"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	op := &database.Operation{
+		ChatID:   chatID,
+		AuthorID: int(tgCtx.Sender().ID),
+		Kind:     database.KindRevert,
+		Comment:  fmt.Sprintf("отмена #%d", operationID),
+		Reverts:  operationID,
+	}
+
+	accounts, err := c.db.RevertOperation(ctx, op)
+	if err != nil {
+		log.Errorf("failed to revert operation %d: %v", operationID, err)
+
+		var msg string
+		switch {
+		case errors.Is(err, database.ErrAlreadyReverted):
+			msg = c.messages["operationAlreadyReverted"]
+		case errors.Is(err, database.ErrRevertOfRevert):
+			msg = c.messages["revertCannotBeReverted"]
+		case errors.Is(err, database.ErrOperationNotFound):
+			msg = c.messages["operationNotFound"]
+		default:
+			msg = c.messages["failedToRevertOperation"]
+		}
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	var msg = fmt.Sprintf("Операция #%d отменена (#%d): \n", operationID, op.ID)
+	msg += generateBalanceMessage(accounts, c.userDisplay(ctx, int(tgCtx.Sender().ID), base))
+	return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message(), ParseMode: tg.ModeHTML})
+}
+
+func parseOperationID(payload string) (int64, error) {
+	payload = strings.TrimPrefix(strings.TrimSpace(payload), "#")
+	operationID, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if operationID <= 0 {
+		return 0, fmt.Errorf("operation id must be positive: %d", operationID)
+	}
+	return operationID, nil
+}
@@ -0,0 +1,142 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"moneyjar/pkg/currency"
+	"moneyjar/pkg/database"
+	"moneyjar/pkg/money"
+	"moneyjar/pkg/rates"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	tg "gopkg.in/telebot.v3"
+)
+
+var reSettlePayload = regexp.MustCompile(`^@([\p{L}\d_]+)(?: +([\d.,]+[kKкК]?) ?([^\s\d@;.,-][^\s@;]*))?$`)
+
+type settlePayload struct {
+	account  database.Account
+	amount   money.Amount
+	currency currency.Currency
+}
+
+func (c Core) settleCommand(tgCtx tg.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	if len(tgCtx.Message().Payload) == 0 {
+		log.Debug("ignored message without payload")
+		return nil
+	}
+
+	settle, err := c.parseSettlePayload(ctx, tgCtx)
+	if err != nil {
+		log.Errorf("failed to parse settle payload: %v", err)
+		msg := c.messages["failedToParsePayload"]
+		switch {
+		case errors.Is(err, errUnknownCurrency):
+			msg = fmt.Sprintf(c.messages["unknownCurrency"], strings.Join(c.currencies.Codes(), ", "))
+		case errors.Is(err, errBadAmount):
+			msg = c.messages["failedToParseAmount"]
+		}
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	chatID := settle.account.ChatID
+	base, err := c.ledgerCurrency(ctx, chatID)
+	if err != nil {
+		log.Errorf("failed to get ledger currency: %v", err)
+		msg := c.messages["failedToGetLedger"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	var (
+		amount         money.Amount
+		originalAmount money.Amount
+		cur            = base
+		quote          = rates.Quote{Rate: 1}
+	)
+	if settle.currency.Code == "" {
+		// Without amount settlement clears the whole balance between users
+		balance, err := c.db.GetBalance(ctx, chatID, settle.account.FromUser, settle.account.ToUser)
+		if err != nil {
+			log.Errorf("failed to get balance: %v", err)
+			msg := c.messages["failedToGetAccounts"]
+			return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+		}
+		amount = balance.Neg()
+		originalAmount = amount
+	} else {
+		cur, originalAmount = settle.currency, settle.amount
+		amount, quote, err = c.convertToBase(ctx, chatID, settle.currency, settle.amount, base, time.Time{})
+		if err != nil {
+			log.Errorf("failed to convert currency to %s: %v", base.Code, err)
+			msg := c.messages["failedToConvertCurrency"]
+			return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+		}
+	}
+
+	if amount.IsZero() {
+		msg := c.messages["nothingToSettle"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	op := &database.Operation{
+		ChatID:   chatID,
+		AuthorID: int(tgCtx.Sender().ID),
+		Kind:     database.KindSettlement,
+
+		Currency:     cur.Code,
+		ExchangeRate: quote.Rate,
+		RateSource:   quote.Source,
+		MessageID:    tgCtx.Message().ID,
+	}
+	transfer := database.Transfer{Account: settle.account, Amount: amount, OriginalAmount: originalAmount}
+	updatedAccounts, err := c.db.UpdateAccounts(ctx, op, []database.Transfer{transfer})
+	if err != nil {
+		log.Errorf("failed to update accounts: %v", err)
+		msg := c.messages["failedToUpdateBalance"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	var msg = fmt.Sprintf("Долг возвращен (#%d): \n", op.ID)
+	msg += generateBalanceMessage(updatedAccounts, c.userDisplay(ctx, int(tgCtx.Sender().ID), base))
+	msg += c.rateWarning(quote)
+	return c.replyOperation(ctx, tgCtx, op.ID, msg)
+}
+
+func (c Core) parseSettlePayload(ctx context.Context, tgCtx tg.Context) (*settlePayload, error) {
+	match := reSettlePayload.FindStringSubmatch(mentionsByID(tgCtx.Message()))
+	if len(match) < 4 {
+		return nil, fmt.Errorf("invalid payload: %d of 4 matches", len(match))
+	}
+
+	var payload settlePayload
+	if match[2] != "" {
+		amount, err := parseNumber(match[2])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse amount: %w", err)
+		}
+		cur, err := c.parseCurrency(match[3])
+		if err != nil {
+			return nil, err
+		}
+		payload.amount, err = money.FromRat(amount, cur.Decimals)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse amount: %w", err)
+		}
+		payload.currency = cur
+	}
+
+	account, err := c.mentionToAccount(ctx, tgCtx.Chat().ID, int(tgCtx.Sender().ID), match[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to get userId from name %s: %v", match[1], err)
+	}
+	payload.account = account
+
+	return &payload, nil
+}
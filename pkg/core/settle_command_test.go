@@ -0,0 +1,32 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_reSettlePayload(t *testing.T) {
+	var match []string
+
+	var (
+		withoutAmount         = `@test`
+		expectedWithoutAmount = []string{withoutAmount, "test", "", ""}
+	)
+	match = reSettlePayload.FindStringSubmatch(withoutAmount)
+	assert.Equal(t, expectedWithoutAmount, match)
+
+	var (
+		withAmount         = `@test 25.5 gel`
+		expectedWithAmount = []string{withAmount, "test", "25.5", "gel"}
+	)
+	match = reSettlePayload.FindStringSubmatch(withAmount)
+	assert.Equal(t, expectedWithAmount, match)
+
+	match = reSettlePayload.FindStringSubmatch(`@test 25.5`)
+	assert.Nil(t, match)
+
+	// Virtual members can have names in any alphabet
+	match = reSettlePayload.FindStringSubmatch(`@Вася 10 gel`)
+	assert.Equal(t, []string{`@Вася 10 gel`, "Вася", "10", "gel"}, match)
+}
@@ -0,0 +1,148 @@
+package core
+
+import (
+	"fmt"
+	"moneyjar/pkg/database"
+	"moneyjar/pkg/money"
+	"sort"
+)
+
+// payment is a single money transfer from debtor to creditor
+type payment struct {
+	From     int
+	FromName string
+	To       int
+	ToName   string
+	Amount   money.Amount
+}
+
+// simplifyDebts finds small set of payments that settles everyone using minimum cash flow algorithm:
+// net balance of every user is computed from merged accounts, then the biggest debtor pays the biggest creditor
+// until all balances are zero. It produces at most n-1 payments for n users.
+func simplifyDebts(accounts []database.Account) ([]payment, error) {
+	var (
+		net   = make(map[int]money.Amount)
+		names = make(map[int]string)
+		err   error
+	)
+
+	for _, account := range accounts {
+		// Positive balance means ToUser owes FromUser
+		if net[account.FromUser], err = net[account.FromUser].Add(account.Balance); err != nil {
+			return nil, fmt.Errorf("failed to compute net balance of user %d: %w", account.FromUser, err)
+		}
+		if net[account.ToUser], err = net[account.ToUser].Sub(account.Balance); err != nil {
+			return nil, fmt.Errorf("failed to compute net balance of user %d: %w", account.ToUser, err)
+		}
+		names[account.FromUser] = account.FromUserName
+		names[account.ToUser] = account.ToUserName
+	}
+
+	type member struct {
+		id     int
+		amount money.Amount
+	}
+	var creditors, debtors []member
+	for id, amount := range net {
+		switch amount.Sign() {
+		case 1:
+			creditors = append(creditors, member{id: id, amount: amount})
+		case -1:
+			debtors = append(debtors, member{id: id, amount: amount.Neg()})
+		}
+	}
+
+	byAmount := func(members []member) func(i, j int) bool {
+		return func(i, j int) bool {
+			if cmp := members[i].amount.Cmp(members[j].amount); cmp != 0 {
+				return cmp > 0
+			}
+			return members[i].id < members[j].id
+		}
+	}
+
+	var payments []payment
+	for len(creditors) > 0 && len(debtors) > 0 {
+		sort.Slice(creditors, byAmount(creditors))
+		sort.Slice(debtors, byAmount(debtors))
+
+		creditor, debtor := &creditors[0], &debtors[0]
+		amount := creditor.amount
+		if debtor.amount.Cmp(amount) < 0 {
+			amount = debtor.amount
+		}
+		payments = append(payments, payment{
+			From:     debtor.id,
+			FromName: names[debtor.id],
+			To:       creditor.id,
+			ToName:   names[creditor.id],
+			Amount:   amount,
+		})
+
+		// Both amounts are at least as big as payment, so they can't overflow
+		creditor.amount, _ = creditor.amount.Sub(amount)
+		debtor.amount, _ = debtor.amount.Sub(amount)
+		if creditor.amount.IsZero() {
+			creditors = creditors[1:]
+		}
+		if debtor.amount.IsZero() {
+			debtors = debtors[1:]
+		}
+	}
+	return payments, nil
+}
+
+// settlementTransfers turns payments plan into ledger transfers. Payments are recorded as settlements,
+// and whatever is left on pair accounts afterwards is cleared by netting transfers, so every balance becomes zero.
+func settlementTransfers(accounts []database.Account, payments []payment) ([]database.Transfer, error) {
+	residuals := make(map[string]*database.Transfer, len(accounts))
+	for _, account := range accounts {
+		residuals[account.String()] = &database.Transfer{Account: account, Amount: account.Balance}
+	}
+
+	transfers := make([]database.Transfer, 0, len(payments)+len(accounts))
+	for _, p := range payments {
+		account := database.Account{FromUser: p.From, FromUserName: p.FromName, ToUser: p.To, ToUserName: p.ToName}
+		transfers = append(transfers, database.Transfer{
+			Account:        account,
+			Amount:         p.Amount,
+			OriginalAmount: p.Amount,
+			Kind:           database.KindSettlement,
+		})
+
+		residual, ok := residuals[account.String()]
+		if !ok {
+			residual = &database.Transfer{Account: account}
+			residuals[account.String()] = residual
+		}
+		var err error
+		if residual.Account.FromUser == p.From {
+			residual.Amount, err = residual.Amount.Add(p.Amount)
+		} else {
+			residual.Amount, err = residual.Amount.Sub(p.Amount)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute residual of account %s: %w", account, err)
+		}
+	}
+
+	keys := make([]string, 0, len(residuals))
+	for key := range residuals {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		residual := residuals[key]
+		if residual.Amount.IsZero() {
+			continue
+		}
+		transfers = append(transfers, database.Transfer{
+			Account:        residual.Account,
+			Amount:         residual.Amount.Neg(),
+			OriginalAmount: residual.Amount.Neg(),
+			Kind:           database.KindNetting,
+		})
+	}
+	return transfers, nil
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"moneyjar/pkg/currency"
+	"moneyjar/pkg/database"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	tg "gopkg.in/telebot.v3"
+)
+
+const simplifyApplyArg = "apply"
+
+func (c Core) simplifyCommand(tgCtx tg.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	chatID := tgCtx.Chat().ID
+	payload := strings.TrimSpace(tgCtx.Message().Payload)
+
+	if payload != "" && payload != simplifyApplyArg {
+		msg := c.messages["failedToParsePayload"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	base, err := c.ledgerCurrency(ctx, chatID)
+	if err != nil {
+		log.Errorf("failed to get ledger currency: %v", err)
+		msg := c.messages["failedToGetLedger"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	// Applied plan records settlements on behalf of everyone in chat, so it's up to admin
+	if payload == simplifyApplyArg {
+		var isAdmin bool
+		isAdmin, err = c.isChatAdmin(tgCtx)
+		if err != nil {
+			log.Errorf("failed to check if user %d is admin: %v", tgCtx.Sender().ID, err)
+			msg := c.messages["failedToUpdateBalance"]
+			return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+		}
+		if !isAdmin {
+			msg := c.messages["onlyAdminCanApplySimplify"]
+			return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+		}
+	}
+
+	accounts, err := c.db.GetAccountsInChat(ctx, chatID)
+	if err != nil {
+		log.Errorf("failed to get accounts of chat %d: %v", chatID, err)
+		msg := c.messages["failedToGetAccounts"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	payments, err := simplifyDebts(accounts)
+	if err != nil {
+		log.Errorf("failed to simplify debts of chat %d: %v", chatID, err)
+		msg := c.messages["failedToGetAccounts"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+	if len(payments) == 0 {
+		msg := c.messages["nothingToSimplify"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	if payload != simplifyApplyArg {
+		msg := "План взаиморасчетов: \n"
+		msg += generatePaymentsMessage(payments, base)
+		msg += "\nЧтобы записать его как возвраты долгов, админ чата может отправить /simplify apply"
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message(), ParseMode: tg.ModeHTML})
+	}
+
+	op := &database.Operation{
+		ChatID:   chatID,
+		AuthorID: int(tgCtx.Sender().ID),
+		Kind:     database.KindSettlement,
+		Comment:  "/simplify",
+
+		Currency:     base.Code,
+		ExchangeRate: 1,
+	}
+	transfers, err := settlementTransfers(accounts, payments)
+	if err != nil {
+		log.Errorf("failed to make settlement transfers: %v", err)
+		msg := c.messages["failedToUpdateBalance"]
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+	// Plan is applied only to balances it was computed from
+	if _, err = c.db.SettleAccounts(ctx, op, accounts, transfers); err != nil {
+		log.Errorf("failed to apply settlement plan: %v", err)
+		msg := c.messages["failedToUpdateBalance"]
+		if errors.Is(err, database.ErrBalancesChanged) {
+			msg = c.messages["simplifyBalancesChanged"]
+		}
+		return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message()})
+	}
+
+	msg := fmt.Sprintf("Долги закрыты по плану (#%d): \n", op.ID)
+	msg += generatePaymentsMessage(payments, base)
+	return tgCtx.Send(msg, &tg.SendOptions{ReplyTo: tgCtx.Message(), ParseMode: tg.ModeHTML})
+}
+
+func generatePaymentsMessage(payments []payment, cur currency.Currency) (msg string) {
+	const rowTemplate = "%d) <b>@%s</b> платит <b>@%s</b> %s\n"
+
+	for i, p := range payments {
+		msg += fmt.Sprintf(rowTemplate, i+1, html.EscapeString(p.FromName), html.EscapeString(p.ToName), cur.Format(p.Amount))
+	}
+	return msg
+}
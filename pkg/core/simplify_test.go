@@ -0,0 +1,94 @@
+package core
+
+import (
+	"moneyjar/pkg/database"
+	"moneyjar/pkg/money"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_simplifyDebts(t *testing.T) {
+	tests := []struct {
+		name     string
+		accounts []database.Account
+		want     []payment
+	}{
+		{
+			name: "chain",
+			accounts: []database.Account{
+				// b owes a 10, c owes b 10
+				{FromUser: 1, FromUserName: "a", ToUser: 2, ToUserName: "b", Balance: money.New(10, 2)},
+				{FromUser: 2, FromUserName: "b", ToUser: 3, ToUserName: "c", Balance: money.New(10, 2)},
+				{FromUser: 1, FromUserName: "a", ToUser: 3, ToUserName: "c", Balance: money.New(0, 2)},
+			},
+			want: []payment{
+				{From: 3, FromName: "c", To: 1, ToName: "a", Amount: money.New(10, 2)},
+			},
+		},
+		{
+			name: "cycle",
+			accounts: []database.Account{
+				{FromUser: 1, FromUserName: "a", ToUser: 2, ToUserName: "b", Balance: money.New(10, 2)},
+				{FromUser: 2, FromUserName: "b", ToUser: 3, ToUserName: "c", Balance: money.New(10, 2)},
+				{FromUser: 3, FromUserName: "c", ToUser: 1, ToUserName: "a", Balance: money.New(10, 2)},
+			},
+			want: nil,
+		},
+		{
+			name: "one creditor",
+			accounts: []database.Account{
+				{FromUser: 1, FromUserName: "a", ToUser: 2, ToUserName: "b", Balance: money.New(30, 2)},
+				{FromUser: 3, FromUserName: "c", ToUser: 1, ToUserName: "a", Balance: money.New(-20, 2)},
+			},
+			want: []payment{
+				{From: 2, FromName: "b", To: 1, ToName: "a", Amount: money.New(30, 2)},
+				{From: 3, FromName: "c", To: 1, ToName: "a", Amount: money.New(20, 2)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := simplifyDebts(tt.accounts)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_settlementTransfers(t *testing.T) {
+	accounts := []database.Account{
+		{FromUser: 1, FromUserName: "a", ToUser: 2, ToUserName: "b", Balance: money.New(10, 2)},
+		{FromUser: 2, FromUserName: "b", ToUser: 3, ToUserName: "c", Balance: money.New(10, 2)},
+		{FromUser: 1, FromUserName: "a", ToUser: 3, ToUserName: "c", Balance: money.New(0, 2)},
+	}
+	payments, err := simplifyDebts(accounts)
+	assert.NoError(t, err)
+	transfers, err := settlementTransfers(accounts, payments)
+	assert.NoError(t, err)
+
+	balances := make(map[string]money.Amount)
+	for _, account := range accounts {
+		balances[account.String()] = account.Balance
+	}
+	for _, transfer := range transfers {
+		key := transfer.Account.String()
+		// Transfer amount is applied to the side of its FromUser
+		var sameSide bool
+		for _, account := range accounts {
+			if account.String() == key {
+				sameSide = account.FromUser == transfer.Account.FromUser
+			}
+		}
+		if sameSide {
+			balances[key], err = balances[key].Add(transfer.Amount)
+		} else {
+			balances[key], err = balances[key].Sub(transfer.Amount)
+		}
+		assert.NoError(t, err)
+	}
+	for key, balance := range balances {
+		assert.True(t, balance.IsZero(), "account %s is not settled", key)
+	}
+	assert.Equal(t, database.KindSettlement, transfers[0].Kind)
+}
@@ -0,0 +1,125 @@
+package core
+
+import (
+	"fmt"
+	"math/big"
+	"moneyjar/pkg/money"
+)
+
+type splitMode int
+
+const (
+	// splitEqual divides amount equally between author and targets
+	splitEqual splitMode = iota
+	// splitExact takes amount owed by target as is
+	splitExact
+	// splitPercent takes percent of amount
+	splitPercent
+	// splitShares divides amount by weights, author and targets without weight have weight of 1
+	splitShares
+)
+
+type splitTarget struct {
+	username string
+	mode     splitMode
+	// value is exact amount, percent or weight depending on mode
+	value *big.Rat
+}
+
+// splitError points to target whose part of amount can't be taken, reason is shown to user as is
+type splitError struct {
+	index  int
+	reason string
+}
+
+func (e *splitError) Error() string {
+	return fmt.Sprintf("failed to split amount at target %d: %s", e.index, e.reason)
+}
+
+// splitRatios returns part of amount owed by every target, parts are exact fractions.
+// *splitError is returned if split of some target does not fit the others.
+func splitRatios(amount *big.Rat, targets []splitTarget) ([]*big.Rat, error) {
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no targets to split amount")
+	}
+
+	mode := splitEqual
+	for i, target := range targets {
+		if target.mode == splitEqual {
+			continue
+		}
+		if mode != splitEqual && mode != target.mode {
+			return nil, &splitError{index: i, reason: "нельзя смешивать суммы, проценты и доли в одном долге"}
+		}
+		mode = target.mode
+	}
+
+	ratios := make([]*big.Rat, len(targets))
+
+	switch mode {
+	case splitEqual:
+		// Single target owes the whole amount, otherwise author pays his part too
+		parts := int64(len(targets))
+		if len(targets) > 1 {
+			parts++
+		}
+		for i := range ratios {
+			ratios[i] = big.NewRat(1, parts)
+		}
+	case splitExact, splitPercent:
+		total := new(big.Rat).Abs(amount)
+		if mode == splitPercent {
+			total = big.NewRat(100, 1)
+		}
+		sum := new(big.Rat)
+		for i, target := range targets {
+			if target.mode != mode {
+				return nil, &splitError{index: i, reason: "укажите сумму или процент для каждого пользователя"}
+			}
+			ratios[i] = new(big.Rat)
+			if total.Sign() != 0 {
+				ratios[i].Quo(target.value, total)
+			}
+			sum.Add(sum, target.value)
+			if sum.Cmp(total) > 0 {
+				return nil, &splitError{
+					index:  i,
+					reason: fmt.Sprintf("сумма частей %s больше %s", sum.FloatString(2), total.FloatString(2)),
+				}
+			}
+		}
+	case splitShares:
+		sum := big.NewRat(1, 1)
+		for i, target := range targets {
+			weight := big.NewRat(1, 1)
+			if target.mode == splitShares {
+				weight = target.value
+			}
+			if weight.Sign() <= 0 {
+				return nil, &splitError{index: i, reason: "доля должна быть больше нуля"}
+			}
+			ratios[i] = weight
+			sum.Add(sum, weight)
+		}
+		for i := range ratios {
+			ratios[i] = new(big.Rat).Quo(ratios[i], sum)
+		}
+	}
+	return ratios, nil
+}
+
+// splitAmount divides amount by ratios of targets, the rest is part of the author. Parts are rounded with
+// money.Allocate, so parts of targets and author always sum up to amount, and cent left after rounding
+// of equal parts goes to the author.
+func splitAmount(amount money.Amount, ratios []*big.Rat) (author money.Amount, targets []money.Amount) {
+	authorRatio := big.NewRat(1, 1)
+	for _, ratio := range ratios {
+		authorRatio.Sub(authorRatio, ratio)
+	}
+	if authorRatio.Sign() < 0 {
+		authorRatio.SetInt64(0)
+	}
+
+	parts := money.Allocate(amount, append([]*big.Rat{authorRatio}, ratios...))
+	return parts[0], parts[1:]
+}
@@ -0,0 +1,156 @@
+package core
+
+import (
+	"math/big"
+	"moneyjar/pkg/money"
+	"strconv"
+	"testing"
+	"testing/quick"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_splitRatios(t *testing.T) {
+	tests := []struct {
+		name    string
+		amount  *big.Rat
+		targets []splitTarget
+		want    []*big.Rat
+		wantErr bool
+	}{
+		{
+			name:    "single target owes everything",
+			amount:  rat("100"),
+			targets: []splitTarget{{username: "a"}},
+			want:    []*big.Rat{rat("1")},
+		},
+		{
+			name:    "equal with author",
+			amount:  rat("90"),
+			targets: []splitTarget{{username: "a"}, {username: "b"}},
+			want:    []*big.Rat{rat("1/3"), rat("1/3")},
+		},
+		{
+			name:    "exact",
+			amount:  rat("100"),
+			targets: []splitTarget{{username: "a", mode: splitExact, value: rat("30")}, {username: "b", mode: splitExact, value: rat("20")}},
+			want:    []*big.Rat{rat("0.3"), rat("0.2")},
+		},
+		{
+			name:    "percent",
+			amount:  rat("50"),
+			targets: []splitTarget{{username: "a", mode: splitPercent, value: rat("25")}},
+			want:    []*big.Rat{rat("0.25")},
+		},
+		{
+			name:    "weights",
+			amount:  rat("100"),
+			targets: []splitTarget{{username: "a", mode: splitShares, value: rat("2")}, {username: "b"}},
+			want:    []*big.Rat{rat("0.5"), rat("0.25")},
+		},
+		{
+			name:    "exact more than amount",
+			amount:  rat("10"),
+			targets: []splitTarget{{username: "a", mode: splitExact, value: rat("30")}},
+			wantErr: true,
+		},
+		{
+			name:    "mixed modes",
+			amount:  rat("100"),
+			targets: []splitTarget{{username: "a", mode: splitExact, value: rat("30")}, {username: "b", mode: splitPercent, value: rat("20")}},
+			wantErr: true,
+		},
+		{
+			name:    "exact without amount for target",
+			amount:  rat("100"),
+			targets: []splitTarget{{username: "a", mode: splitExact, value: rat("30")}, {username: "b"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitRatios(tt.amount, tt.targets)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("splitRatios() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_splitAmount(t *testing.T) {
+	tests := []struct {
+		name       string
+		amount     money.Amount
+		ratios     []*big.Rat
+		wantAuthor money.Amount
+		want       []money.Amount
+	}{
+		{
+			name:       "cent left from equal parts goes to author",
+			amount:     money.New(10000, 2),
+			ratios:     []*big.Rat{rat("1/3"), rat("1/3")},
+			wantAuthor: money.New(3334, 2),
+			want:       []money.Amount{money.New(3333, 2), money.New(3333, 2)},
+		},
+		{
+			name:       "single target owes everything",
+			amount:     money.New(-10001, 2),
+			ratios:     []*big.Rat{rat("1")},
+			wantAuthor: money.New(0, 2),
+			want:       []money.Amount{money.New(-10001, 2)},
+		},
+		{
+			name:       "exact amounts",
+			amount:     money.New(100, 0),
+			ratios:     []*big.Rat{rat("0.3"), rat("0.2")},
+			wantAuthor: money.New(50, 0),
+			want:       []money.Amount{money.New(30, 0), money.New(20, 0)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			author, got := splitAmount(tt.amount, tt.ratios)
+			assert.Equal(t, tt.wantAuthor, author)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_splitAmount_sumsUp(t *testing.T) {
+	modes := []splitMode{splitEqual, splitPercent, splitShares}
+
+	sumsUp := func(units int32, decimals, mode uint8, values []uint8) bool {
+		if len(values) == 0 {
+			return true
+		}
+		amount := money.New(int64(units), int(decimals%4))
+
+		targets := make([]splitTarget, len(values))
+		for i, v := range values {
+			targets[i] = splitTarget{username: strconv.Itoa(i), mode: modes[int(mode)%len(modes)]}
+			switch targets[i].mode {
+			case splitPercent:
+				// Percents of all targets must fit into 100
+				targets[i].value = big.NewRat(int64(v)%(100/int64(len(values))+1), 1)
+			case splitShares:
+				targets[i].value = big.NewRat(int64(v)+1, 1)
+			}
+		}
+
+		ratios, err := splitRatios(amount.Rat(), targets)
+		if err != nil {
+			return false
+		}
+		author, parts := splitAmount(amount, ratios)
+		total := author
+		for _, part := range parts {
+			if total, err = total.Add(part); err != nil {
+				return false
+			}
+		}
+		return len(parts) == len(targets) && total.Cmp(amount) == 0
+	}
+	assert.NoError(t, quick.Check(sumsUp, &quick.Config{MaxCount: 1000}))
+}
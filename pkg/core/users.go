@@ -0,0 +1,111 @@
+package core
+
+import (
+	"context"
+	"moneyjar/pkg/database"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	log "github.com/sirupsen/logrus"
+	tg "gopkg.in/telebot.v3"
+)
+
+// reCommand splits command and payload the same way telebot does
+var reCommand = regexp.MustCompile(`^(/\w+)(@(\w+))?(\s|$)(.+)?`)
+
+// refreshNames is middleware which keeps username and display name of sender up to date, so mentions
+// of new username work right after rename. Names are written only when they differ from ones seen last time.
+func (c Core) refreshNames(next tg.HandlerFunc) tg.HandlerFunc {
+	return func(tgCtx tg.Context) error {
+		sender := tgCtx.Sender()
+		if sender == nil || sender.IsBot {
+			return next(tgCtx)
+		}
+
+		user := userFromTelegram(sender)
+		key := user.Name + "\n" + user.DisplayName
+		if known, ok := c.knownNames.Load(user.ID); !ok || known != key {
+			ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+			defer cancel()
+
+			if err := c.db.UpdateUserNames(ctx, user); err != nil {
+				log.Errorf("failed to refresh names: %v", err)
+			} else {
+				c.knownNames.Store(user.ID, key)
+			}
+		}
+		return next(tgCtx)
+	}
+}
+
+// userFromTelegram returns user with names from Telegram account
+func userFromTelegram(u *tg.User) database.User {
+	return database.User{
+		ID:          int(u.ID),
+		Name:        u.Username,
+		DisplayName: strings.TrimSpace(u.FirstName + " " + u.LastName),
+	}
+}
+
+// senderName returns username of sender or display name if sender has no username
+func senderName(u *tg.User) string {
+	user := userFromTelegram(u)
+	if user.Name == "" {
+		return user.DisplayName
+	}
+	return user.Name
+}
+
+// mentionsByID returns payload of command where mentions of users without username are replaced by
+// @ and user ID, e.g. @123456. Usernames can't start with digit, so such mention always means ID.
+func mentionsByID(m *tg.Message) string {
+	var hasTextMentions bool
+	for _, entity := range m.Entities {
+		if entity.Type == tg.EntityTMention && entity.User != nil {
+			hasTextMentions = true
+		}
+	}
+	if !hasTextMentions {
+		return m.Payload
+	}
+
+	// Entity offsets are in UTF-16 code units, entities are replaced from the end so offsets stay valid
+	text := utf16.Encode([]rune(m.Text))
+	for i := len(m.Entities) - 1; i >= 0; i-- {
+		entity := m.Entities[i]
+		if entity.Type != tg.EntityTMention || entity.User == nil {
+			continue
+		}
+		start, end := entity.Offset, entity.Offset+entity.Length
+		if start < 0 || end > len(text) {
+			continue
+		}
+		mention := utf16.Encode([]rune("@" + strconv.FormatInt(entity.User.ID, 10)))
+		text = append(text[:start:start], append(mention, text[end:]...)...)
+	}
+
+	match := reCommand.FindStringSubmatch(string(utf16.Decode(text)))
+	if match == nil {
+		return ""
+	}
+	return match[5]
+}
+
+// mentionedID returns user ID of mention made by mentionsByID
+func mentionedID(username string) (int, bool) {
+	if username == "" || username[0] < '0' || username[0] > '9' {
+		return 0, false
+	}
+	id, err := strconv.Atoi(username)
+	return id, err == nil
+}
+
+// mentionToAccount gets account in chat ledger from user to mentioned one
+func (c Core) mentionToAccount(ctx context.Context, chatID int64, fromUser int, username string) (database.Account, error) {
+	if id, ok := mentionedID(username); ok {
+		return c.db.UserIDToAccount(ctx, chatID, fromUser, id)
+	}
+	return c.db.UserNameToAccount(ctx, chatID, fromUser, username)
+}
@@ -0,0 +1,70 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	tg "gopkg.in/telebot.v3"
+)
+
+func Test_mentionsByID(t *testing.T) {
+	ivan := &tg.User{ID: 123, FirstName: "Иван"}
+	tests := []struct {
+		name    string
+		message *tg.Message
+		want    string
+	}{
+		{
+			name:    "no text mentions",
+			message: &tg.Message{Text: "/debt 100 gel @a", Payload: "100 gel @a"},
+			want:    "100 gel @a",
+		},
+		{
+			name: "text mention",
+			message: &tg.Message{
+				Text:     "/debt 100 gel Иван Петров; ужин",
+				Entities: tg.Entities{{Type: tg.EntityCommand, Offset: 0, Length: 5}, {Type: tg.EntityTMention, Offset: 14, Length: 11, User: ivan}},
+			},
+			want: "100 gel @123; ужин",
+		},
+		{
+			name: "offsets in UTF-16",
+			message: &tg.Message{
+				Text:     "/debt 🍕 100 gel @a Иван",
+				Entities: tg.Entities{{Type: tg.EntityTMention, Offset: 20, Length: 4, User: ivan}},
+			},
+			want: "🍕 100 gel @a @123",
+		},
+		{
+			name: "command with bot name",
+			message: &tg.Message{
+				Text:     "/settle@moneyjar_bot Иван",
+				Entities: tg.Entities{{Type: tg.EntityTMention, Offset: 21, Length: 4, User: ivan}},
+			},
+			want: "@123",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, mentionsByID(tt.message))
+		})
+	}
+}
+
+func Test_mentionedID(t *testing.T) {
+	id, ok := mentionedID("123")
+	assert.True(t, ok)
+	assert.Equal(t, 123, id)
+
+	_, ok = mentionedID("user123")
+	assert.False(t, ok)
+
+	_, ok = mentionedID("")
+	assert.False(t, ok)
+}
+
+func Test_senderName(t *testing.T) {
+	assert.Equal(t, "ivan", senderName(&tg.User{Username: "ivan", FirstName: "Иван"}))
+	assert.Equal(t, "Иван Петров", senderName(&tg.User{FirstName: "Иван", LastName: "Петров"}))
+	assert.Equal(t, "Иван", senderName(&tg.User{FirstName: "Иван"}))
+}
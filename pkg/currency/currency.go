@@ -0,0 +1,169 @@
+package currency
+
+import (
+	"fmt"
+	"math/big"
+	"moneyjar/pkg/money"
+	"sort"
+	"strings"
+)
+
+// MaxDecimals is the biggest number of digits in minor units of currency
+const MaxDecimals = 4
+
+// Currency describes currency known to bot
+type Currency struct {
+	// Code is ISO 4217 code, e.g. USD
+	Code string `koanf:"code"`
+	// Symbols are signs written next to amount, the first one is used for display
+	Symbols []string `koanf:"symbols"`
+	// Words are localized names in any grammatical form recognized in commands
+	Words []string `koanf:"words"`
+	// Plurals are forms used to display amounts in words
+	Plurals Plurals `koanf:"plurals"`
+	// Decimals is number of digits after decimal point in minor units
+	Decimals int `koanf:"decimals"`
+}
+
+// Plurals are forms of currency name for numbers ending with 1, with 2-4 and the rest, like in Russian
+type Plurals struct {
+	One  string `koanf:"one"`
+	Few  string `koanf:"few"`
+	Many string `koanf:"many"`
+}
+
+// Defaults are used when currencies are not set in config
+var Defaults = []Currency{
+	{
+		Code:     "USD",
+		Symbols:  []string{"$"},
+		Words:    []string{"доллар", "доллара", "долларов", "долларах", "бакс", "бакса", "баксов"},
+		Plurals:  Plurals{One: "доллар", Few: "доллара", Many: "долларов"},
+		Decimals: 2,
+	},
+	{
+		Code:     "RUB",
+		Symbols:  []string{"₽"},
+		Words:    []string{"рубль", "рубля", "рублей", "рублях", "руб"},
+		Plurals:  Plurals{One: "рубль", Few: "рубля", Many: "рублей"},
+		Decimals: 2,
+	},
+	{
+		Code:     "GEL",
+		Symbols:  []string{"₾"},
+		Words:    []string{"лари", "лар"},
+		Plurals:  Plurals{One: "лари", Few: "лари", Many: "лари"},
+		Decimals: 2,
+	},
+}
+
+// Registry looks up currencies by codes, symbols and words
+type Registry struct {
+	currencies map[string]Currency
+	aliases    map[string]string
+}
+
+// NewRegistry returns Registry of given currencies
+func NewRegistry(currencies []Currency) (*Registry, error) {
+	r := &Registry{
+		currencies: make(map[string]Currency, len(currencies)),
+		aliases:    make(map[string]string),
+	}
+
+	for _, cur := range currencies {
+		cur.Code = strings.ToUpper(strings.TrimSpace(cur.Code))
+		if len(cur.Code) != 3 {
+			return nil, fmt.Errorf("bad currency code: %q", cur.Code)
+		}
+		if cur.Decimals < 0 || cur.Decimals > MaxDecimals {
+			return nil, fmt.Errorf("bad number of decimals for %s: %d", cur.Code, cur.Decimals)
+		}
+		if _, ok := r.currencies[cur.Code]; ok {
+			return nil, fmt.Errorf("duplicate currency: %s", cur.Code)
+		}
+		r.currencies[cur.Code] = cur
+
+		aliases := append([]string{cur.Code}, cur.Symbols...)
+		aliases = append(aliases, cur.Words...)
+		for _, alias := range aliases {
+			key := strings.ToLower(alias)
+			if code, ok := r.aliases[key]; ok && code != cur.Code {
+				return nil, fmt.Errorf("alias %q is used by %s and %s", alias, code, cur.Code)
+			}
+			r.aliases[key] = cur.Code
+		}
+	}
+	return r, nil
+}
+
+// Parse finds currency by code, symbol or word in any case
+func (r *Registry) Parse(s string) (Currency, bool) {
+	code, ok := r.aliases[strings.ToLower(strings.TrimSpace(s))]
+	if !ok {
+		return Currency{}, false
+	}
+	return r.currencies[code], true
+}
+
+// Get finds currency by ISO code
+func (r *Registry) Get(code string) (Currency, bool) {
+	cur, ok := r.currencies[strings.ToUpper(code)]
+	return cur, ok
+}
+
+// Codes returns sorted codes of all known currencies
+func (r *Registry) Codes() []string {
+	codes := make([]string, 0, len(r.currencies))
+	for code := range r.currencies {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// Symbol returns sign used to display currency, code if currency has no symbols
+func (c Currency) Symbol() string {
+	if len(c.Symbols) == 0 {
+		return " " + c.Code
+	}
+	return c.Symbols[0]
+}
+
+// Format shows amount rounded to minor units with currency symbol, e.g. 12.50$
+func (c Currency) Format(amount money.Amount) string {
+	rescaled, err := amount.Rescale(c.Decimals)
+	if err != nil {
+		// Amount is shown with its own decimals rather than not shown at all
+		return amount.String() + c.Symbol()
+	}
+	return rescaled.String() + c.Symbol()
+}
+
+// FormatWords shows amount with plural form of currency name, e.g. 5 долларов
+func (c Currency) FormatWords(amount money.Amount) string {
+	number := amount.Reduce().String()
+	if c.Plurals.Many == "" {
+		return number + " " + c.Code
+	}
+	return number + " " + c.Plural(amount)
+}
+
+// Plural returns form of currency name for amount
+func (c Currency) Plural(amount money.Amount) string {
+	r := amount.Rat()
+	if !r.IsInt() {
+		// Fractions take genitive singular in Russian: 1.5 доллара
+		return c.Plurals.Few
+	}
+
+	n := new(big.Int).Abs(r.Num())
+	n100 := new(big.Int).Mod(n, big.NewInt(100)).Int64()
+	switch n10 := n100 % 10; {
+	case n10 == 1 && n100 != 11:
+		return c.Plurals.One
+	case n10 >= 2 && n10 <= 4 && (n100 < 12 || n100 > 14):
+		return c.Plurals.Few
+	default:
+		return c.Plurals.Many
+	}
+}
@@ -0,0 +1,66 @@
+package currency
+
+import (
+	"moneyjar/pkg/money"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry_Parse(t *testing.T) {
+	registry, err := NewRegistry(Defaults)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		input    string
+		wantCode string
+		wantOk   bool
+	}{
+		{input: "usd", wantCode: "USD", wantOk: true},
+		{input: "USD", wantCode: "USD", wantOk: true},
+		{input: "$", wantCode: "USD", wantOk: true},
+		{input: "долларов", wantCode: "USD", wantOk: true},
+		{input: "Рублей", wantCode: "RUB", wantOk: true},
+		{input: "₾", wantCode: "GEL", wantOk: true},
+		{input: "eur", wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			cur, ok := registry.Parse(tt.input)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.wantCode, cur.Code)
+		})
+	}
+}
+
+func TestNewRegistry(t *testing.T) {
+	_, err := NewRegistry([]Currency{{Code: "USD", Symbols: []string{"$"}}, {Code: "CAD", Symbols: []string{"$"}}})
+	assert.Error(t, err, "ambiguous symbol")
+
+	_, err = NewRegistry([]Currency{{Code: "DOLLAR"}})
+	assert.Error(t, err, "bad code")
+
+	registry, err := NewRegistry([]Currency{{Code: "jpy", Symbols: []string{"¥"}}})
+	assert.NoError(t, err)
+	_, ok := registry.Get("JPY")
+	assert.True(t, ok)
+}
+
+func TestCurrency_Format(t *testing.T) {
+	usd := Defaults[0]
+	assert.Equal(t, "12.50$", usd.Format(money.New(125, 1)))
+	assert.Equal(t, "-0.01$", usd.Format(money.New(-5, 3)))
+	assert.Equal(t, "1 доллар", usd.FormatWords(money.New(1, 0)))
+	assert.Equal(t, "3 доллара", usd.FormatWords(money.New(300, 2)))
+	assert.Equal(t, "11 долларов", usd.FormatWords(money.New(11, 0)))
+	assert.Equal(t, "21 доллар", usd.FormatWords(money.New(21, 0)))
+	assert.Equal(t, "1.5 доллара", usd.FormatWords(money.New(150, 2)))
+	assert.Equal(t, "-112 долларов", usd.FormatWords(money.New(-112, 0)))
+
+	jpy := Currency{Code: "JPY", Decimals: 0}
+	assert.Equal(t, "1235 JPY", jpy.Format(money.New(12346, 1)))
+	assert.Equal(t, "5 JPY", jpy.FormatWords(money.New(5, 0)))
+
+	kwd := Currency{Code: "KWD", Decimals: 3}
+	assert.Equal(t, "12.345 KWD", kwd.Format(money.New(12345, 3)))
+}
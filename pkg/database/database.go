@@ -2,236 +2,2747 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
-	"math"
+	"moneyjar/pkg/money"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq" // nolint:revive
 	log "github.com/sirupsen/logrus"
 )
 
+var (
+	// ErrOperationNotFound is returned when there is no operation with given ID in chat ledger
+	ErrOperationNotFound = errors.New("operation not found")
+	// ErrAlreadyReverted is returned on attempt to revert operation twice
+	ErrAlreadyReverted = errors.New("operation is already reverted")
+	// ErrRevertOfRevert is returned when revert is asked to be reverted, operation should be written again instead
+	ErrRevertOfRevert = errors.New("revert can't be reverted")
+	// ErrLedgerNotFound is returned when chat has no ledger
+	ErrLedgerNotFound = errors.New("ledger not found")
+	// ErrLedgerExists is returned on attempt to move ledger to chat which already has members or records
+	ErrLedgerExists = errors.New("ledger already exists")
+	// ErrUserNotRegistered is returned when there is no user with given username in chat ledger
+	ErrUserNotRegistered = errors.New("user is not registered")
+	// ErrBalancesChanged is returned when balances of chat ledger are not the ones operation was computed from
+	ErrBalancesChanged = errors.New("balances changed")
+	// ErrUserExists is returned on attempt to add member with name which is already used in chat ledger
+	ErrUserExists = errors.New("user already exists")
+	// ErrVirtualUserNotFound is returned when chat ledger has no virtual member with given name
+	ErrVirtualUserNotFound = errors.New("virtual user not found")
+	// ErrPinnedRateNotFound is returned when chat has no active pinned rate of currency
+	ErrPinnedRateNotFound = errors.New("pinned rate not found")
+	// ErrProposalNotFound is returned if there is no debt proposal with given ID in chat
+	ErrProposalNotFound = errors.New("debt proposal not found")
+	// ErrProposalClosed is returned if debt proposal is already accepted, declined or expired
+	ErrProposalClosed = errors.New("debt proposal is closed")
+	// ErrNotDebtor is returned if user is not allowed to answer debt proposal
+	ErrNotDebtor = errors.New("user is not a debtor of proposal")
+	// ErrNotParty is returned if user is neither author nor user of operation
+	ErrNotParty = errors.New("user is not a party of operation")
+	// ErrDisputeNotFound is returned if operation has no dispute
+	ErrDisputeNotFound = errors.New("dispute not found")
+)
+
 // Database wraps DB-related logic
 type Database struct {
 	conn *sqlx.DB
 }
 
-// New returns new Database
-func New(dsn string) (*Database, error) {
-	conn, err := sqlx.Open("postgres", dsn)
+// New returns new Database
+func New(dsn string) (*Database, error) {
+	conn, err := sqlx.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &Database{
+		conn: conn,
+	}, nil
+}
+
+// CreateUser creates new User in database and adds it to the chat ledger. Pending transfers to the username
+// are written to accounts of the user, and accounts updated by them are returned. ErrUserExists is returned if
+// virtual member of chat has the same name, the member has to be linked to the user instead.
+func (db Database) CreateUser(ctx context.Context, chatID int64, user User) ([]Account, error) {
+	tx, err := db.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer func() {
+		commitErr := tx.Commit()
+		if commitErr != nil {
+			log.Errorf("failed to commit: %v", err)
+		}
+	}()
+
+	const virtualQuery = `
+		select exists(
+		    select 1 from chat_members m join users u on u.id = m.user_id
+		    where m.chat_id = $1 and u.is_virtual and lower(u.name) = lower($2)
+		)`
+
+	const createUserQuery = `
+		insert into
+		    users (id, name, display_name)
+		values
+		    ($1, $2, $3)
+		on conflict (id) do update set
+		    name = excluded.name,
+		    display_name = excluded.display_name`
+
+	// Mention of the name would match both users
+	var exists bool
+	if user.Name != "" {
+		if err = tx.QueryRowxContext(ctx, virtualQuery, chatID, user.Name).Scan(&exists); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return nil, fmt.Errorf("failed to rollback: %v", err)
+			}
+			return nil, fmt.Errorf("failed to check name %s: %v", user.Name, err)
+		}
+	}
+	if exists {
+		err = fmt.Errorf("%w: %s", ErrUserExists, user.Name)
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, createUserQuery, user.ID, user.Name, user.DisplayName)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return nil, fmt.Errorf("failed to create user: %v", err)
+	}
+
+	if err = addMember(ctx, tx, chatID, user.ID); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return nil, err
+	}
+
+	accounts, err := db.claimPendingTransfers(ctx, tx, chatID, user.ID, user.Name)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// CreateVirtualUser creates member of chat ledger who is not on Telegram, such users have negative IDs.
+// Pending transfers to the name are written to accounts of new member like in CreateUser.
+// createdBy is kept only if that user is registered, it allows the user to link the member later.
+func (db Database) CreateVirtualUser(ctx context.Context, chatID int64, name string, createdBy int) (User, []Account, error) {
+	tx, err := db.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return User{}, nil, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer func() {
+		commitErr := tx.Commit()
+		if commitErr != nil {
+			log.Errorf("db.CreateVirtualUser: failed to commit: %v", err)
+		}
+	}()
+
+	// Telegram usernames are unique, so name of user known to bot is reserved even if the user has not
+	// registered in this chat yet, otherwise mention of the name would become ambiguous when they do
+	const existsQuery = `
+		select exists(
+		    select 1 from chat_members m join users u on u.id = m.user_id where m.chat_id = $1 and lower(u.name) = lower($2)
+		) or exists(
+		    select 1 from users where not is_virtual and lower(name) = lower($2)
+		)`
+
+	const createUserQuery = `
+		insert into
+		    users (id, name, is_virtual, created_by)
+		values
+		    (nextval('virtual_users_id_seq'), $1, true, (select id from users where id = $2))
+		returning id, name, is_virtual, coalesce(created_by, 0) as created_by`
+
+	var exists bool
+	if err = tx.QueryRowxContext(ctx, existsQuery, chatID, name).Scan(&exists); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return User{}, nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return User{}, nil, fmt.Errorf("failed to check name %s: %v", name, err)
+	}
+	if exists {
+		err = fmt.Errorf("%w: %s", ErrUserExists, name)
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return User{}, nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return User{}, nil, err
+	}
+
+	var user User
+	if err = tx.GetContext(ctx, &user, createUserQuery, name, createdBy); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return User{}, nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return User{}, nil, fmt.Errorf("failed to create virtual user: %v", err)
+	}
+
+	if err = addMember(ctx, tx, chatID, user.ID); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return User{}, nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return User{}, nil, err
+	}
+
+	accounts, err := db.claimPendingTransfers(ctx, tx, chatID, user.ID, name)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return User{}, nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return User{}, nil, err
+	}
+	return user, accounts, nil
+}
+
+// GetVirtualUser returns virtual member of chat ledger by name
+func (db Database) GetVirtualUser(ctx context.Context, chatID int64, name string) (User, error) {
+	const query = `
+		select
+		       u.id, u.name, u.display_name, u.is_virtual, coalesce(u.created_by, 0) as created_by
+		from
+		     users u
+		         join chat_members m on m.user_id = u.id
+		where
+		      m.chat_id = $1
+		  and
+		      u.is_virtual
+		  and
+		      lower(u.name) = lower($2)`
+
+	var user User
+	err := db.conn.GetContext(ctx, &user, query, chatID, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, fmt.Errorf("%w: %s", ErrVirtualUserNotFound, name)
+	}
+	if err != nil {
+		return User{}, fmt.Errorf("failed to get virtual user %s: %v", name, err)
+	}
+	return user, nil
+}
+
+// LinkVirtualUser merges virtual member of chat ledger with name into user with Telegram account.
+// Balances of virtual member are added to accounts of the user and log records are rewritten to the user,
+// except debts between them, which are dropped as debts to oneself: their records are compensated by
+// corrections and superseded, so operations with them can still be reverted and edited. Open debt
+// proposals are rewritten the same way.
+func (db Database) LinkVirtualUser(ctx context.Context, chatID int64, name string, userID int) error {
+	tx, err := db.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer func() {
+		commitErr := tx.Commit()
+		if commitErr != nil {
+			log.Errorf("db.LinkVirtualUser: failed to commit: %v", err)
+		}
+	}()
+
+	const virtualQuery = `
+		select
+		       u.id
+		from
+		     users u
+		         join chat_members m on m.user_id = u.id
+		where
+		      m.chat_id = $1
+		  and
+		      u.is_virtual
+		  and
+		      lower(u.name) = lower($2)
+		for update`
+
+	const memberQuery = `select exists(select 1 from chat_members where chat_id = $1 and user_id = $2)`
+
+	var virtualID int
+	if err = tx.QueryRowxContext(ctx, virtualQuery, chatID, name).Scan(&virtualID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = fmt.Errorf("%w: %s", ErrVirtualUserNotFound, name)
+		} else {
+			err = fmt.Errorf("failed to get virtual user %s: %v", name, err)
+		}
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("failed to rollback: %v", err)
+		}
+		return err
+	}
+
+	var isMember bool
+	if err = tx.QueryRowxContext(ctx, memberQuery, chatID, userID).Scan(&isMember); err != nil || !isMember {
+		if err == nil {
+			err = fmt.Errorf("%w: %d", ErrUserNotRegistered, userID)
+		} else {
+			err = fmt.Errorf("failed to check membership of user %d: %v", userID, err)
+		}
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("failed to rollback: %v", err)
+		}
+		return err
+	}
+
+	mergeQueries := []struct {
+		query string
+		args  []interface{}
+		what  string
+	}{
+		{
+			query: `
+				update accounts a set balance = a.balance + v.balance
+				from accounts v
+				where v.chat_id = $1 and a.chat_id = $1
+				  and v.from_user = $2 and v.to_user != $3 and a.from_user = $3 and a.to_user = v.to_user`,
+			args: []interface{}{chatID, virtualID, userID},
+			what: "accounts",
+		},
+		{
+			query: `
+				update accounts a set balance = a.balance + v.balance
+				from accounts v
+				where v.chat_id = $1 and a.chat_id = $1
+				  and v.to_user = $2 and v.from_user != $3 and a.to_user = $3 and a.from_user = v.from_user`,
+			args: []interface{}{chatID, virtualID, userID},
+			what: "flipped accounts",
+		},
+		{
+			query: `delete from accounts where chat_id = $1 and (from_user = $2 or to_user = $2)`,
+			args:  []interface{}{chatID, virtualID},
+			what:  "accounts of virtual user",
+		},
+		{
+			query: `
+				delete from debt_proposal_transfers t
+				using debt_proposals p
+				where p.id = t.proposal_id and p.chat_id = $1 and p.status = $4
+				  and ((t.from_user = $2 and t.to_user = $3) or (t.from_user = $3 and t.to_user = $2))`,
+			args: []interface{}{chatID, virtualID, userID, ProposalOpen},
+			what: "proposal transfers between users",
+		},
+		{
+			query: `
+				update debt_proposal_transfers t set from_user = $3
+				from debt_proposals p
+				where p.id = t.proposal_id and p.chat_id = $1 and p.status = $4 and t.from_user = $2`,
+			args: []interface{}{chatID, virtualID, userID, ProposalOpen},
+			what: "proposal transfers",
+		},
+		{
+			query: `
+				update debt_proposal_transfers t set to_user = $3
+				from debt_proposals p
+				where p.id = t.proposal_id and p.chat_id = $1 and p.status = $4 and t.to_user = $2`,
+			args: []interface{}{chatID, virtualID, userID, ProposalOpen},
+			what: "flipped proposal transfers",
+		},
+		{
+			query: `delete from chat_members where chat_id = $1 and user_id = $2`,
+			args:  []interface{}{chatID, virtualID},
+			what:  "virtual user from chat",
+		},
+		{
+			query: `update users set linked_to = $2 where id = $1`,
+			args:  []interface{}{virtualID, userID},
+			what:  "virtual user",
+		},
+	}
+	for _, merge := range mergeQueries {
+		if _, err = tx.ExecContext(ctx, merge.query, merge.args...); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return fmt.Errorf("failed to rollback: %v", err)
+			}
+			return fmt.Errorf("failed to merge %s: %v", merge.what, err)
+		}
+	}
+
+	if err = linkLog(ctx, tx, chatID, virtualID, userID); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("failed to rollback: %v", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// linkLog rewrites log records of virtual member to user, records between them are compensated and superseded
+func linkLog(ctx context.Context, tx *sqlx.Tx, chatID int64, virtualID, userID int) error {
+	const recordsQuery = `
+		select
+		       id, operation_id, from_user, to_user, balance_change, superseded
+		from
+		     transactionlog
+		where
+		      chat_id = $1
+		  and
+		      (from_user = $2 or to_user = $2)
+		order by id
+		for update`
+
+	const relinkQuery = `update transactionlog set from_user = $2, to_user = $3 where id = $1`
+
+	// Correction is written by the user at time of link
+	const correctionQuery = `
+		insert into transactionlog
+		    (chat_id, operation_id, author_id, from_user, to_user, balance_change, comment, kind,
+		     original_amount, currency, exchange_rate, effective_date, rate_source, message_id, superseded)
+		select
+		    chat_id, operation_id, $2, from_user, to_user, -balance_change, comment, $3,
+		    -original_amount, currency, exchange_rate, effective_date, rate_source, message_id, true
+		from
+		    transactionlog
+		where
+		    id = $1`
+
+	const supersedeQuery = `update transactionlog set superseded = true where id = $1`
+
+	var records []Log
+	if err := tx.SelectContext(ctx, &records, recordsQuery, chatID, virtualID); err != nil {
+		return fmt.Errorf("failed to get log records of virtual user %d: %v", virtualID, err)
+	}
+
+	relinked, dropped := linkRecords(records, virtualID, userID)
+	for _, record := range relinked {
+		if _, err := tx.ExecContext(ctx, relinkQuery, record.ID, record.FromUser, record.ToUser); err != nil {
+			return fmt.Errorf("failed to merge log record %d: %v", record.ID, err)
+		}
+	}
+	for _, record := range dropped {
+		if record.Superseded {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, correctionQuery, record.ID, userID, KindCorrection); err != nil {
+			return fmt.Errorf("failed to compensate log record %d: %v", record.ID, err)
+		}
+		if _, err := tx.ExecContext(ctx, supersedeQuery, record.ID); err != nil {
+			return fmt.Errorf("failed to supersede log record %d: %v", record.ID, err)
+		}
+	}
+	return nil
+}
+
+// linkRecords returns records of virtual member rewritten to user and records between them, which have
+// no account after link
+func linkRecords(records []Log, virtualID, userID int) (relinked, dropped []Log) {
+	for _, record := range records {
+		if record.FromUser == userID || record.ToUser == userID {
+			dropped = append(dropped, record)
+			continue
+		}
+		if record.FromUser == virtualID {
+			record.FromUser = userID
+		}
+		if record.ToUser == virtualID {
+			record.ToUser = userID
+		}
+		relinked = append(relinked, record)
+	}
+	return relinked, dropped
+}
+
+// addMember adds user to chat ledger and creates accounts of the user with other members
+func addMember(ctx context.Context, tx *sqlx.Tx, chatID int64, userID int) error {
+	const createMemberQuery = "insert into chat_members (chat_id, user_id) values ($1, $2)"
+	if _, err := tx.ExecContext(ctx, createMemberQuery, chatID, userID); err != nil {
+		return fmt.Errorf("failed to add user to chat: %v", err)
+	}
+
+	const createAccountsQuery = `
+		insert into
+		    accounts (chat_id, from_user, to_user, is_flipped) 
+		    select $1, $2 to_user, user_id from_user, false from chat_members where chat_id = $1 and user_id != $2
+		    union
+		    select $1, user_id to_user, $2 from_user, true from chat_members where chat_id = $1 and user_id != $2`
+	if _, err := tx.ExecContext(ctx, createAccountsQuery, chatID, userID); err != nil {
+		return fmt.Errorf("failed to create accounts: %v", err)
+	}
+	return nil
+}
+
+// claimPendingTransfers writes pending transfers to username as transfers to user with the same operations.
+// Transfers are dated by the day they were made, not by the day they were claimed.
+func (db Database) claimPendingTransfers(
+	ctx context.Context, tx *sqlx.Tx, chatID int64, userID int, username string,
+) ([]Account, error) {
+	if username == "" {
+		return nil, nil
+	}
+
+	const pendingQuery = `
+		delete from
+		    pending_transfers
+		where
+		    chat_id = $1
+		  and
+		    lower(to_username) = lower($2)
+		returning
+		    id, chat_id, operation_id, author_id, from_user, to_username, kind, amount,
+		    coalesce(original_amount, 0) as original_amount,
+		    coalesce(currency, '') as currency,
+		    coalesce(exchange_rate, 0) as exchange_rate,
+		    coalesce(rate_source, '') as rate_source,
+		    coalesce(effective_date, created_at::date) as effective_date,
+		    comment,
+		    coalesce(message_id, 0) as message_id`
+
+	var pending []PendingTransfer
+	if err := tx.SelectContext(ctx, &pending, pendingQuery, chatID, username); err != nil {
+		return nil, fmt.Errorf("failed to get pending transfers to %s: %v", username, err)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].ID < pending[j].ID })
+
+	var (
+		accounts []Account
+		indexes  = make(map[string]int)
+	)
+	for _, p := range pending {
+		op := &Operation{
+			ID:           p.OperationID,
+			ChatID:       chatID,
+			AuthorID:     p.AuthorID,
+			Kind:         p.Kind,
+			Comment:      p.Comment,
+			Currency:     p.Currency,
+			ExchangeRate: p.ExchangeRate,
+			RateSource:   p.RateSource,
+			MessageID:    p.MessageID,
+		}
+		if p.EffectiveDate.Valid {
+			op.EffectiveDate = p.EffectiveDate.Time
+		}
+		transfer := Transfer{
+			Account:        Account{ChatID: chatID, FromUser: p.FromUser, ToUser: userID},
+			Amount:         p.Amount,
+			OriginalAmount: p.OriginalAmount,
+			Kind:           p.Kind,
+		}
+
+		account, err := db.updateAccount(ctx, tx, op, transfer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to claim pending transfer %d: %v", p.ID, err)
+		}
+		// Only the last state of every account is returned
+		if i, ok := indexes[account.String()]; ok {
+			accounts[i] = account
+			continue
+		}
+		indexes[account.String()] = len(accounts)
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
+
+// CreateLedger creates ledger of chat if it does not exist yet
+func (db Database) CreateLedger(ctx context.Context, ledger Ledger) error {
+	const query = `
+		insert into
+		    ledgers (chat_id, base_currency, base_decimals)
+		values
+		    (:chat_id, :base_currency, :base_decimals)
+		on conflict (chat_id) do nothing`
+
+	if _, err := db.conn.NamedExecContext(ctx, query, ledger); err != nil {
+		return fmt.Errorf("failed to create ledger: %v", err)
+	}
+	return nil
+}
+
+// GetLedger returns ledger of chat, ErrLedgerNotFound is returned if chat has no ledger yet
+func (db Database) GetLedger(ctx context.Context, chatID int64) (Ledger, error) {
+	const query = `select chat_id, base_currency, base_decimals, confirm_debts from ledgers where chat_id = $1`
+
+	var ledger Ledger
+	err := db.conn.GetContext(ctx, &ledger, query, chatID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Ledger{}, fmt.Errorf("%w: %d", ErrLedgerNotFound, chatID)
+	}
+	if err != nil {
+		return Ledger{}, fmt.Errorf("failed to get ledger of chat %d: %v", chatID, err)
+	}
+	return ledger, nil
+}
+
+// ledgerTables are tables with data of chat ledger, they are keyed by chat_id
+var ledgerTables = []string{
+	"ledgers", "chat_members", "accounts", "transactionlog", "transactionlog_edits", "pending_transfers",
+	"pinned_rates", "debt_proposals", "disputes", "operation_messages",
+}
+
+// MoveLedger hands ledger of chat fromChatID over to chat toChatID, e.g. default ledger with data recorded before
+// ledgers were split by chat. Target chat must have no members and records, its empty ledger settings are
+// replaced by ones of the moved ledger.
+func (db Database) MoveLedger(ctx context.Context, fromChatID, toChatID int64) error {
+	tx, err := db.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer func() {
+		commitErr := tx.Commit()
+		if commitErr != nil {
+			log.Errorf("db.MoveLedger: failed to commit: %v", err)
+		}
+	}()
+
+	const usedQuery = `
+		select
+		    exists(select 1 from chat_members where chat_id = $1) or
+		    exists(select 1 from accounts where chat_id = $1) or
+		    exists(select 1 from transactionlog where chat_id = $1) or
+		    exists(select 1 from pending_transfers where chat_id = $1) or
+		    exists(select 1 from pinned_rates where chat_id = $1)`
+
+	const deleteLedgerQuery = `delete from ledgers where chat_id = $1`
+
+	var fromUsed, toUsed bool
+	for chatID, used := range map[int64]*bool{fromChatID: &fromUsed, toChatID: &toUsed} {
+		if err = tx.QueryRowxContext(ctx, usedQuery, chatID).Scan(used); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return fmt.Errorf("failed to rollback: %v", err)
+			}
+			return fmt.Errorf("failed to check ledger of chat %d: %v", chatID, err)
+		}
+	}
+	if !fromUsed || toUsed {
+		if !fromUsed {
+			err = fmt.Errorf("%w: %d", ErrLedgerNotFound, fromChatID)
+		} else {
+			err = fmt.Errorf("%w: %d", ErrLedgerExists, toChatID)
+		}
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("failed to rollback: %v", err)
+		}
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, deleteLedgerQuery, toChatID); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("failed to rollback: %v", err)
+		}
+		return fmt.Errorf("failed to delete empty ledger of chat %d: %v", toChatID, err)
+	}
+	for _, table := range ledgerTables {
+		query := fmt.Sprintf(`update %s set chat_id = $2 where chat_id = $1`, table)
+		if _, err = tx.ExecContext(ctx, query, fromChatID, toChatID); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return fmt.Errorf("failed to rollback: %v", err)
+			}
+			return fmt.Errorf("failed to move %s of chat %d: %v", table, fromChatID, err)
+		}
+	}
+	return nil
+}
+
+// RebaseLedger changes base currency of ledger. Balances and log records are converted at rate,
+// which is amount of new base currency for one unit of the old one. Every log record is rounded on its own,
+// and balance of account is the sum of its rounded records, so log still adds up to balances.
+func (db Database) RebaseLedger(ctx context.Context, ledger Ledger, rate float64) error {
+	tx, err := db.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer func() {
+		commitErr := tx.Commit()
+		if commitErr != nil {
+			log.Errorf("db.RebaseLedger: failed to commit: %v", err)
+		}
+	}()
+
+	const lockQuery = `select chat_id from ledgers where chat_id = $1 for update`
+
+	const ledgerQuery = `update ledgers set base_currency = $2, base_decimals = $3 where chat_id = $1`
+
+	// Part of balance which has no log records is converted as is, accounts are rebased before log records
+	const accountsQuery = `
+		update
+		    accounts a
+		set
+		    balance = round((a.balance - l.logged) * $2::numeric, $3) + l.rebased
+		from (
+		    select
+		        a.from_user,
+		        a.to_user,
+		        coalesce(sum(t.balance_change), 0) as logged,
+		        coalesce(sum(round(t.balance_change * $2::numeric, $3)), 0) as rebased
+		    from
+		        accounts a
+		            left join transactionlog t
+		                on t.chat_id = a.chat_id and t.from_user = a.from_user and t.to_user = a.to_user
+		    where
+		        a.chat_id = $1
+		    group by a.from_user, a.to_user
+		) l
+		where
+		    a.chat_id = $1
+		  and
+		    a.from_user = l.from_user
+		  and
+		    a.to_user = l.to_user`
+
+	const logQuery = `
+		update
+		    transactionlog
+		set
+		    balance_change = round(balance_change * $2::numeric, $3),
+		    exchange_rate = exchange_rate * $2::numeric
+		where
+		    chat_id = $1`
+
+	const pendingQuery = `
+		update
+		    pending_transfers
+		set
+		    amount = round(amount * $2::numeric, $3),
+		    exchange_rate = exchange_rate * $2::numeric
+		where
+		    chat_id = $1`
+
+	const proposalsQuery = `
+		update
+		    debt_proposals
+		set
+		    exchange_rate = exchange_rate * $2::numeric
+		where
+		    chat_id = $1
+		  and
+		    status = 'open'`
+
+	const proposalTransfersQuery = `
+		update
+		    debt_proposal_transfers
+		set
+		    amount = round(amount * $2::numeric, $3)
+		where
+		    proposal_id in (select id from debt_proposals where chat_id = $1 and status = 'open')`
+
+	var chatID int64
+	if err = tx.QueryRowxContext(ctx, lockQuery, ledger.ChatID).Scan(&chatID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = fmt.Errorf("%w: %d", ErrLedgerNotFound, ledger.ChatID)
+		} else {
+			err = fmt.Errorf("failed to get ledger of chat %d: %v", ledger.ChatID, err)
+		}
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("failed to rollback: %v", err)
+		}
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, ledgerQuery, ledger.ChatID, ledger.BaseCurrency, ledger.BaseDecimals); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("failed to rollback: %v", err)
+		}
+		return fmt.Errorf("failed to update ledger: %v", err)
+	}
+	if _, err = tx.ExecContext(ctx, accountsQuery, ledger.ChatID, rate, ledger.BaseDecimals); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("failed to rollback: %v", err)
+		}
+		return fmt.Errorf("failed to rebase accounts: %v", err)
+	}
+	if _, err = tx.ExecContext(ctx, logQuery, ledger.ChatID, rate, ledger.BaseDecimals); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("failed to rollback: %v", err)
+		}
+		return fmt.Errorf("failed to rebase log records: %v", err)
+	}
+	if _, err = tx.ExecContext(ctx, pendingQuery, ledger.ChatID, rate, ledger.BaseDecimals); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("failed to rollback: %v", err)
+		}
+		return fmt.Errorf("failed to rebase pending transfers: %v", err)
+	}
+	if _, err = tx.ExecContext(ctx, proposalsQuery, ledger.ChatID, rate); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("failed to rollback: %v", err)
+		}
+		return fmt.Errorf("failed to rebase debt proposals: %v", err)
+	}
+	if _, err = tx.ExecContext(ctx, proposalTransfersQuery, ledger.ChatID, rate, ledger.BaseDecimals); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("failed to rollback: %v", err)
+		}
+		return fmt.Errorf("failed to rebase transfers of debt proposals: %v", err)
+	}
+	return nil
+}
+
+// UpdateAccounts updates accounts in chat ledger with individual amounts in single transaction,
+// ID of written operation is stored to op
+func (db Database) UpdateAccounts(ctx context.Context, op *Operation, transfers []Transfer) ([]Account, error) {
+	tx, err := db.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer func() {
+		commitErr := tx.Commit()
+		if commitErr != nil {
+			log.Errorf("db.UpdateAccounts: failed to commit: %v", err)
+		}
+	}()
+
+	if err = newOperation(ctx, tx, op); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return nil, err
+	}
+
+	accounts, err := db.applyTransfers(ctx, tx, op, transfers)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// SettleAccounts writes transfers of operation computed from accounts of chat ledger. Accounts are locked
+// and compared with given ones in the same transaction, ErrBalancesChanged is returned if any balance has changed
+// since accounts were read, e.g. by debt written in between.
+func (db Database) SettleAccounts(ctx context.Context, op *Operation, accounts []Account, transfers []Transfer) ([]Account, error) {
+	tx, err := db.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer func() {
+		commitErr := tx.Commit()
+		if commitErr != nil {
+			log.Errorf("db.SettleAccounts: failed to commit: %v", err)
+		}
+	}()
+
+	const lockQuery = `
+		select
+		       id, chat_id, from_user, to_user, balance, is_flipped
+		from
+		     accounts
+		where
+		      chat_id = $1
+		order by id
+		for update`
+
+	var locked []Account
+	if err = tx.SelectContext(ctx, &locked, lockQuery, op.ChatID); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return nil, fmt.Errorf("failed to lock accounts of chat %d: %v", op.ChatID, err)
+	}
+	if locked, err = mergeDuplicateAccounts(locked); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return nil, err
+	}
+	if !sameBalances(accounts, locked) {
+		err = fmt.Errorf("%w: chat %d", ErrBalancesChanged, op.ChatID)
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return nil, err
+	}
+
+	if err = newOperation(ctx, tx, op); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return nil, err
+	}
+
+	updated, err := db.applyTransfers(ctx, tx, op, transfers)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return nil, err
+	}
+	return updated, nil
+}
+
+// sameBalances reports if merged accounts have the same balances, accounts with zero balance can be missing
+func sameBalances(a, b []Account) bool {
+	balances := make(map[string]money.Amount, len(a))
+	for _, account := range a {
+		balances[account.String()] = balanceOf(account)
+	}
+	for _, account := range b {
+		key := account.String()
+		if balances[key].Cmp(balanceOf(account)) != 0 {
+			return false
+		}
+		delete(balances, key)
+	}
+	for _, balance := range balances {
+		if !balance.IsZero() {
+			return false
+		}
+	}
+	return true
+}
+
+// balanceOf returns balance of account as owed to user with smaller ID, so both sides of account compare equal
+func balanceOf(account Account) money.Amount {
+	if account.FromUser > account.ToUser {
+		return account.Balance.Neg()
+	}
+	return account.Balance
+}
+
+// applyTransfers writes transfers of operation to accounts or to pending transfers of unregistered users
+func (db Database) applyTransfers(ctx context.Context, tx *sqlx.Tx, op *Operation, transfers []Transfer) ([]Account, error) {
+	accounts := make([]Account, 0, len(transfers))
+	for _, transfer := range transfers {
+		if transfer.Kind == "" {
+			transfer.Kind = op.Kind
+		}
+
+		var (
+			account Account
+			err     error
+		)
+		if transfer.Account.IsPending {
+			account, err = db.addPendingTransfer(ctx, tx, op, transfer)
+		} else {
+			account, err = db.updateAccount(ctx, tx, op, transfer)
+		}
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
+
+// RevertOperation writes compensating records for operation op.Reverts, ID of written operation is stored to op
+func (db Database) RevertOperation(ctx context.Context, op *Operation) ([]Account, error) {
+	tx, err := db.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer func() {
+		commitErr := tx.Commit()
+		if commitErr != nil {
+			log.Errorf("db.RevertOperation: failed to commit: %v", err)
+		}
+	}()
+
+	// Records are locked, so concurrent reverts of the same operation wait for each other
+	const recordsQuery = `
+		select
+		       from_user, to_user, kind, balance_change,
+		       coalesce(original_amount, 0) as original_amount,
+		       coalesce(currency, '') as currency,
+		       coalesce(exchange_rate, 0) as exchange_rate,
+		       coalesce(rate_source, '') as rate_source,
+		       coalesce(reverts, 0) as reverts
+		from
+		     transactionlog
+		where
+		      chat_id = $1
+		  and
+		      operation_id = $2
+		  and
+		      not superseded
+		order by id
+		for update`
+
+	const revertedQuery = `select exists(select 1 from transactionlog where chat_id = $1 and reverts = $2)`
+
+	var (
+		records  []Log
+		reverted bool
+		accounts []Account
+	)
+
+	if err = tx.SelectContext(ctx, &records, recordsQuery, op.ChatID, op.Reverts); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return nil, fmt.Errorf("failed to get records of operation %d: %v", op.Reverts, err)
+	}
+
+	// Pending transfers of operation are not claimed by anyone yet, so they are just dropped
+	const pendingQuery = `
+		delete from
+		    pending_transfers
+		where
+		    chat_id = $1
+		  and
+		    operation_id = $2
+		returning
+		    from_user, to_username`
+
+	var pending []PendingTransfer
+	if err = tx.SelectContext(ctx, &pending, pendingQuery, op.ChatID, op.Reverts); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return nil, fmt.Errorf("failed to delete pending transfers of operation %d: %v", op.Reverts, err)
+	}
+
+	if len(records) == 0 && len(pending) == 0 {
+		err = fmt.Errorf("%w: %d", ErrOperationNotFound, op.Reverts)
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return nil, err
+	}
+	if err = checkRevertible(op.Reverts, records); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return nil, err
+	}
+
+	if err = tx.QueryRowxContext(ctx, revertedQuery, op.ChatID, op.Reverts).Scan(&reverted); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return nil, fmt.Errorf("failed to check if operation %d is reverted: %v", op.Reverts, err)
+	}
+	if reverted {
+		err = fmt.Errorf("%w: %d", ErrAlreadyReverted, op.Reverts)
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return nil, err
+	}
+
+	if err = newOperation(ctx, tx, op); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return nil, err
+	}
+
+	// Reverted operation has nothing to argue about anymore
+	if _, err = resolveDispute(ctx, tx, op.ChatID, op.Reverts, op.AuthorID, ResolutionReverted); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return nil, err
+	}
+
+	// Compensating records keep currency of reverted ones
+	if len(records) > 0 {
+		op.Currency, op.ExchangeRate, op.RateSource = records[0].Currency, records[0].ExchangeRate, records[0].RateSource
+	}
+
+	for _, record := range records {
+		var account Account
+		transfer := Transfer{
+			Account:        Account{ChatID: op.ChatID, FromUser: record.FromUser, ToUser: record.ToUser},
+			Amount:         record.BalanceChange.Neg(),
+			OriginalAmount: record.OriginalAmount.Neg(),
+			Kind:           KindRevert,
+		}
+		account, err = db.updateAccount(ctx, tx, op, transfer)
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return nil, fmt.Errorf("failed to rollback: %v", err)
+			}
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+
+	for _, p := range pending {
+		var account Account
+		account, err = pendingAccount(ctx, tx, op.ChatID, p.FromUser, p.ToUsername)
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return nil, fmt.Errorf("failed to rollback: %v", err)
+			}
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
+
+// EditOperation replaces transfers of operation op.ID with new ones in single transaction. Log stays
+// append-only: previous records are compensated by correction records and marked superseded, then new
+// records are written with the same operation ID. Previous version is also kept in transactionlog_edits.
+// Open dispute on operation is resolved by the edit.
+func (db Database) EditOperation(ctx context.Context, op *Operation, transfers []Transfer) ([]Account, error) {
+	tx, err := db.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer func() {
+		commitErr := tx.Commit()
+		if commitErr != nil {
+			log.Errorf("db.EditOperation: failed to commit: %v", err)
+		}
+	}()
+
+	// Records are locked, so concurrent edits and reverts of the same operation wait for each other
+	const recordsQuery = `
+		select
+		       from_user, to_user, balance_change, ts, coalesce(message_id, 0) as message_id
+		from
+		     transactionlog
+		where
+		      chat_id = $1
+		  and
+		      operation_id = $2
+		  and
+		      not superseded
+		order by id
+		for update`
+
+	const pendingQuery = `
+		select
+		    from_user, to_username, coalesce(message_id, 0) as message_id
+		from
+		    pending_transfers
+		where
+		    chat_id = $1
+		  and
+		    operation_id = $2
+		for update`
+
+	const revertedQuery = `select exists(select 1 from transactionlog where chat_id = $1 and reverts = $2)`
+
+	const versionQuery = `
+		select
+		    coalesce(max(version), 0) + 1
+		from
+		    transactionlog_edits
+		where
+		    chat_id = $1
+		  and
+		    operation_id = $2`
+
+	const archiveQuery = `
+		insert into transactionlog_edits
+		    (chat_id, operation_id, version, edited_by, author_id, from_user, to_user, kind, balance_change,
+		     original_amount, currency, exchange_rate, rate_source, comment, effective_date, ts, message_id)
+		select
+		    chat_id, operation_id, $3, $4, author_id, from_user, to_user, kind, balance_change,
+		    original_amount, currency, exchange_rate, rate_source, comment, effective_date, ts, message_id
+		from
+		    transactionlog
+		where
+		    chat_id = $1
+		  and
+		    operation_id = $2
+		  and
+		    not superseded`
+
+	const archivePendingQuery = `
+		insert into transactionlog_edits
+		    (chat_id, operation_id, version, edited_by, author_id, from_user, to_username, kind, balance_change,
+		     original_amount, currency, exchange_rate, rate_source, comment, effective_date, ts, message_id)
+		select
+		    chat_id, operation_id, $3, $4, author_id, from_user, to_username, kind, amount,
+		    original_amount, currency, exchange_rate, rate_source, comment, effective_date, created_at, message_id
+		from
+		    pending_transfers
+		where
+		    chat_id = $1
+		  and
+		    operation_id = $2`
+
+	// Correction records are written by editor at time of edit
+	const correctionQuery = `
+		insert into transactionlog
+		    (chat_id, operation_id, author_id, from_user, to_user, balance_change, comment, kind,
+		     original_amount, currency, exchange_rate, effective_date, rate_source, message_id, superseded)
+		select
+		    chat_id, operation_id, $3, from_user, to_user, -balance_change, comment, $4,
+		    -original_amount, currency, exchange_rate, effective_date, rate_source, message_id, true
+		from
+		    transactionlog
+		where
+		    chat_id = $1
+		  and
+		    operation_id = $2
+		  and
+		    not superseded
+		order by id`
+
+	const supersedeQuery = `update transactionlog set superseded = true where chat_id = $1 and operation_id = $2`
+
+	// Pending transfers are not ledger records, they are kept in transactionlog_edits only
+	const deletePendingQuery = `delete from pending_transfers where chat_id = $1 and operation_id = $2`
+
+	// New records keep time of the original ones, so operation stays in its place in history
+	const tsQuery = `update transactionlog set ts = $3 where chat_id = $1 and operation_id = $2 and not superseded`
+
+	var (
+		records  []Log
+		pending  []PendingTransfer
+		reverted bool
+		version  int
+	)
+
+	if err = tx.SelectContext(ctx, &records, recordsQuery, op.ChatID, op.ID); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return nil, fmt.Errorf("failed to get records of operation %d: %v", op.ID, err)
+	}
+	if err = tx.SelectContext(ctx, &pending, pendingQuery, op.ChatID, op.ID); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return nil, fmt.Errorf("failed to get pending transfers of operation %d: %v", op.ID, err)
+	}
+	if len(records) == 0 && len(pending) == 0 {
+		err = fmt.Errorf("%w: %d", ErrOperationNotFound, op.ID)
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return nil, err
+	}
+	// Operation stays linked to message it was written by
+	if op.MessageID == 0 {
+		if len(records) > 0 {
+			op.MessageID = records[0].MessageID
+		} else {
+			op.MessageID = pending[0].MessageID
+		}
+	}
+
+	if err = tx.QueryRowxContext(ctx, revertedQuery, op.ChatID, op.ID).Scan(&reverted); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return nil, fmt.Errorf("failed to check if operation %d is reverted: %v", op.ID, err)
+	}
+	if reverted {
+		err = fmt.Errorf("%w: %d", ErrAlreadyReverted, op.ID)
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return nil, err
+	}
+
+	if err = tx.QueryRowxContext(ctx, versionQuery, op.ChatID, op.ID).Scan(&version); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return nil, fmt.Errorf("failed to get version of operation %d: %v", op.ID, err)
+	}
+	for _, query := range []string{archiveQuery, archivePendingQuery} {
+		if _, err = tx.ExecContext(ctx, query, op.ChatID, op.ID, version, op.AuthorID); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return nil, fmt.Errorf("failed to rollback: %v", err)
+			}
+			return nil, fmt.Errorf("failed to keep previous version of operation %d: %v", op.ID, err)
+		}
+	}
+
+	// Accounts are shown once in order they were touched, with balance after the edit
+	var (
+		accounts []Account
+		index    = make(map[string]int)
+	)
+	addAccount := func(account Account) {
+		key := account.String()
+		if account.IsPending {
+			key = fmt.Sprintf("%d:@%s", account.FromUser, strings.ToLower(account.ToUserName))
+		}
+		if i, ok := index[key]; ok {
+			accounts[i] = account
+			return
+		}
+		index[key] = len(accounts)
+		accounts = append(accounts, account)
+	}
+
+	for _, record := range records {
+		var account Account
+		account, err = moveBalance(ctx, tx, op.ChatID, record.FromUser, record.ToUser, record.BalanceChange.Neg())
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return nil, fmt.Errorf("failed to rollback: %v", err)
+			}
+			return nil, err
+		}
+		addAccount(account)
+	}
+	if _, err = tx.ExecContext(ctx, correctionQuery, op.ChatID, op.ID, op.AuthorID, KindCorrection); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return nil, fmt.Errorf("failed to write corrections of operation %d: %v", op.ID, err)
+	}
+	for _, query := range []string{supersedeQuery, deletePendingQuery} {
+		if _, err = tx.ExecContext(ctx, query, op.ChatID, op.ID); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return nil, fmt.Errorf("failed to rollback: %v", err)
+			}
+			return nil, fmt.Errorf("failed to replace previous version of operation %d: %v", op.ID, err)
+		}
+	}
+	for _, p := range pending {
+		var account Account
+		if account, err = pendingAccount(ctx, tx, op.ChatID, p.FromUser, p.ToUsername); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return nil, fmt.Errorf("failed to rollback: %v", err)
+			}
+			return nil, err
+		}
+		addAccount(account)
+	}
+
+	updated, err := db.applyTransfers(ctx, tx, op, transfers)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return nil, err
+	}
+	for _, account := range updated {
+		addAccount(account)
+	}
+
+	if len(records) > 0 {
+		if _, err = tx.ExecContext(ctx, tsQuery, op.ChatID, op.ID, records[0].TS); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return nil, fmt.Errorf("failed to rollback: %v", err)
+			}
+			return nil, fmt.Errorf("failed to keep time of operation %d: %v", op.ID, err)
+		}
+	}
+
+	if _, err = resolveDispute(ctx, tx, op.ChatID, op.ID, op.AuthorID, ResolutionEdited); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// checkRevertible tells whether operation with records can be reverted. Revert of revert would apply
+// the original operation again while it's still shown as reverted.
+func checkRevertible(operationID int64, records []Log) error {
+	for _, record := range records {
+		if record.Kind == KindRevert || record.Reverts != 0 {
+			return fmt.Errorf("%w: %d", ErrRevertOfRevert, operationID)
+		}
+	}
+	return nil
+}
+
+// newOperation reserves ID for operation, operations share sequence with transactionlog records
+func newOperation(ctx context.Context, tx *sqlx.Tx, op *Operation) error {
+	const query = `select nextval(pg_get_serial_sequence('transactionlog', 'id'))`
+
+	if err := tx.QueryRowxContext(ctx, query).Scan(&op.ID); err != nil {
+		return fmt.Errorf("failed to get new operation id: %v", err)
+	}
+	return nil
+}
+
+// updateAccount changes balance of single account and writes log record about it
+func (db Database) updateAccount(ctx context.Context, tx *sqlx.Tx, op *Operation, transfer Transfer) (Account, error) {
+	const logQuery = `
+		insert into transactionlog
+		    (chat_id, operation_id, author_id, from_user, to_user, balance_change, comment, kind, reverts,
+		     original_amount, currency, exchange_rate, effective_date, rate_source, message_id)
+		values
+		    ($1, $2, $3, $4, $5, $6, $7, $8, nullif($9, 0), $10, nullif($11, ''), nullif($12, 0), $13, nullif($14, ''),
+		     nullif($15, 0))`
+
+	var (
+		toAccount     = transfer.Account
+		effectiveDate sql.NullTime
+	)
+	if !op.EffectiveDate.IsZero() {
+		effectiveDate.Time, effectiveDate.Valid = op.EffectiveDate, true
+	}
+
+	account, err := moveBalance(ctx, tx, op.ChatID, toAccount.FromUser, toAccount.ToUser, transfer.Amount)
+	if err != nil {
+		return Account{}, err
+	}
+
+	_, err = tx.ExecContext(ctx, logQuery,
+		op.ChatID, op.ID, op.AuthorID, toAccount.FromUser, toAccount.ToUser, transfer.Amount, op.Comment, transfer.Kind,
+		op.Reverts, transfer.OriginalAmount, op.Currency, op.ExchangeRate, effectiveDate, op.RateSource, op.MessageID)
+	if err != nil {
+		return Account{}, fmt.Errorf("failed to insert log record: %v", err)
+	}
+	return account, nil
+}
+
+// moveBalance adds amount to fromUser side of account between users without writing log record
+func moveBalance(ctx context.Context, tx *sqlx.Tx, chatID int64, fromUser, toUser int, amount money.Amount) (Account, error) {
+	const balanceQuery = `
+		update
+			accounts
+		set
+		    balance = case when from_user = $2 then balance + $1 else balance end
+		where
+		    chat_id = $4
+		  and
+		    ((from_user = $3 and to_user = $2) or (from_user = $2 and to_user = $3))
+		returning chat_id, from_user, to_user, balance, is_flipped`
+
+	var updatedAccounts []Account
+	if err := tx.SelectContext(ctx, &updatedAccounts, balanceQuery, amount, fromUser, toUser, chatID); err != nil {
+		return Account{}, fmt.Errorf("failed to update balance: %v", err)
+	}
+
+	updatedAccounts, err := mergeDuplicateAccounts(updatedAccounts)
+	if err != nil {
+		return Account{}, err
+	}
+	if len(updatedAccounts) != 1 {
+		return Account{}, fmt.Errorf("updated accounts after merging still not 1: %d", len(updatedAccounts))
+	}
+	account := updatedAccounts[0]
+
+	(&account).FromUserName, err = userIDtoName(ctx, tx, account.FromUser)
+	if err != nil {
+		return Account{}, fmt.Errorf("failed to resolve FromUser name by id: %v", err)
+	}
+	(&account).ToUserName, err = userIDtoName(ctx, tx, account.ToUser)
+	if err != nil {
+		return Account{}, fmt.Errorf("failed to resolve ToUser name by id: %v", err)
+	}
+	return account, nil
+}
+
+// addPendingTransfer keeps transfer to user who has not registered yet, it's claimed by CreateUser
+func (db Database) addPendingTransfer(ctx context.Context, tx *sqlx.Tx, op *Operation, transfer Transfer) (Account, error) {
+	const query = `
+		insert into pending_transfers
+		    (chat_id, operation_id, author_id, from_user, to_username, kind, amount,
+		     original_amount, currency, exchange_rate, rate_source, effective_date, comment, message_id)
+		values
+		    ($1, $2, $3, $4, $5, $6, $7, $8, nullif($9, ''), nullif($10, 0), nullif($11, ''), $12, $13, nullif($14, 0))`
+
+	var effectiveDate sql.NullTime
+	if !op.EffectiveDate.IsZero() {
+		effectiveDate.Time, effectiveDate.Valid = op.EffectiveDate, true
+	}
+
+	account := transfer.Account
+	_, err := tx.ExecContext(ctx, query,
+		op.ChatID, op.ID, op.AuthorID, account.FromUser, account.ToUserName, transfer.Kind, transfer.Amount,
+		transfer.OriginalAmount, op.Currency, op.ExchangeRate, op.RateSource, effectiveDate, op.Comment, op.MessageID)
+	if err != nil {
+		return Account{}, fmt.Errorf("failed to insert pending transfer to %s: %v", account.ToUserName, err)
+	}
+	return pendingAccount(ctx, tx, op.ChatID, account.FromUser, account.ToUserName)
+}
+
+// pendingAccount sums pending transfers from user to username
+func pendingAccount(ctx context.Context, q sqlx.QueryerContext, chatID int64, fromUserID int, toUsername string) (Account, error) {
+	const query = `
+		select
+		       coalesce(sum(amount), 0)
+		from
+		     pending_transfers
+		where
+		      chat_id = $1
+		  and
+		      from_user = $2
+		  and
+		      lower(to_username) = lower($3)`
+
+	account := Account{ChatID: chatID, FromUser: fromUserID, ToUserName: toUsername, IsPending: true}
+	if err := q.QueryRowxContext(ctx, query, chatID, fromUserID, toUsername).Scan(&account.Balance); err != nil {
+		return Account{}, fmt.Errorf("failed to get pending balance of %s: %v", toUsername, err)
+	}
+
+	var err error
+	if account.FromUserName, err = userIDtoName(ctx, q, fromUserID); err != nil {
+		return Account{}, fmt.Errorf("failed to resolve FromUser name by id: %v", err)
+	}
+	return account, nil
+}
+
+// GetPendingAccounts returns pending accounts of user with users who have not registered yet
+func (db Database) GetPendingAccounts(ctx context.Context, chatID int64, userID int) ([]Account, error) {
+	const query = `
+		select
+		       p.chat_id,
+		       p.from_user,
+		       coalesce(nullif(u.name, ''), u.display_name) from_user_name,
+		       min(p.to_username) to_user_name,
+		       sum(p.amount) balance,
+		       true is_pending
+		from
+		     pending_transfers p
+		         join users u on u.id = p.from_user
+		where
+		      p.chat_id = $1
+		  and
+		      p.from_user = $2
+		group by
+		    p.chat_id, p.from_user, u.name, u.display_name, lower(p.to_username)
+		having
+		    sum(p.amount) != 0
+		order by
+		    to_user_name`
+
+	var accounts []Account
+	if err := db.conn.SelectContext(ctx, &accounts, query, chatID, userID); err != nil {
+		return nil, fmt.Errorf("failed to get pending accounts of user %d: %v", userID, err)
+	}
+	return accounts, nil
+}
+
+// UserNameToAccount gets account in chat ledger from user ID to username, name of linked virtual member refers
+// to the user it's linked to. ErrUserNotRegistered is returned if user is in the ledger and username is not,
+// sql.ErrNoRows if user is not in the ledger either.
+func (db Database) UserNameToAccount(ctx context.Context, chatID int64, fromUserID int, toUsername string) (Account, error) {
+	const query = `
+		select 
+		       is_flipped, to_user
+		from 
+		     accounts
+		where 
+		      chat_id = $1
+		  and
+		      from_user = $2
+		  and 
+		      to_user in (select coalesce(linked_to, id) from users where lower(name) = lower($3))`
+
+	const memberQuery = `select exists(select 1 from chat_members where chat_id = $1 and user_id = $2)`
+
+	var (
+		isFlipped bool
+		userID    int
+	)
+	err := db.conn.QueryRowxContext(ctx, query, chatID, fromUserID, toUsername).Scan(&isFlipped, &userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		var isMember bool
+		if memberErr := db.conn.QueryRowxContext(ctx, memberQuery, chatID, fromUserID).Scan(&isMember); memberErr != nil {
+			return Account{}, fmt.Errorf("failed to check membership of user %d: %v", fromUserID, memberErr)
+		}
+		if isMember {
+			return Account{}, fmt.Errorf("%w: %s", ErrUserNotRegistered, toUsername)
+		}
+	}
+	if err != nil {
+		return Account{}, fmt.Errorf("failed to get user by name: %w", err)
+	}
+	return Account{ChatID: chatID, FromUser: fromUserID, ToUser: userID, IsFlipped: isFlipped}, nil
+}
+
+// UserIDToAccount gets account in chat ledger from user ID to another user ID, ErrUserNotRegistered is returned
+// if there is no such account
+func (db Database) UserIDToAccount(ctx context.Context, chatID int64, fromUserID, toUserID int) (Account, error) {
+	const query = `select is_flipped from accounts where chat_id = $1 and from_user = $2 and to_user = $3`
+
+	var isFlipped bool
+	err := db.conn.QueryRowxContext(ctx, query, chatID, fromUserID, toUserID).Scan(&isFlipped)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Account{}, fmt.Errorf("%w: %d", ErrUserNotRegistered, toUserID)
+	}
+	if err != nil {
+		return Account{}, fmt.Errorf("failed to get account of user %d: %v", toUserID, err)
+	}
+	return Account{ChatID: chatID, FromUser: fromUserID, ToUser: toUserID, IsFlipped: isFlipped}, nil
+}
+
+// UpdateUserNames changes username and display name of user if they differ from stored ones,
+// nothing happens if user is not registered
+func (db Database) UpdateUserNames(ctx context.Context, user User) error {
+	const query = `
+		update
+		    users
+		set
+		    name = $2,
+		    display_name = $3
+		where
+		    id = $1
+		  and
+		    (name != $2 or display_name != $3)`
+
+	if _, err := db.conn.ExecContext(ctx, query, user.ID, user.Name, user.DisplayName); err != nil {
+		return fmt.Errorf("failed to update names of user %d: %v", user.ID, err)
+	}
+	return nil
+}
+
+func userIDtoName(ctx context.Context, q sqlx.QueryerContext, userID int) (string, error) {
+	const query = `select coalesce(nullif(name, ''), display_name) from users where id = $1`
+
+	var name string
+	if err := q.QueryRowxContext(ctx, query, userID).Scan(&name); err != nil {
+		return "", fmt.Errorf("failed to get user by id: %v", err)
+	}
+	return name, nil
+}
+
+// GetBalance returns how much toUser owes fromUser in chat ledger, negative balance means fromUser is a debtor
+func (db Database) GetBalance(ctx context.Context, chatID int64, fromUserID, toUserID int) (money.Amount, error) {
+	const query = `
+		select
+		       coalesce(sum(case when from_user = $2 then balance else -balance end), 0)
+		from
+		     accounts
+		where
+		      chat_id = $1
+		  and
+		      ((from_user = $2 and to_user = $3) or (from_user = $3 and to_user = $2))`
+
+	var balance money.Amount
+	if err := db.conn.QueryRowxContext(ctx, query, chatID, fromUserID, toUserID).Scan(&balance); err != nil {
+		return money.Amount{}, fmt.Errorf("failed to get balance between %d and %d: %v", fromUserID, toUserID, err)
+	}
+	return balance, nil
+}
+
+// GetAccountsWithUser returns accounts connected to user in chat ledger
+func (db Database) GetAccountsWithUser(ctx context.Context, chatID int64, userID int) ([]Account, error) {
+	const query = `
+		select
+		       a.id,
+		       chat_id,
+		       from_user,
+		       coalesce(nullif(u1.name, ''), u1.display_name) from_user_name,
+		       to_user,
+		       coalesce(nullif(u2.name, ''), u2.display_name) to_user_name,
+		       balance,
+		       is_flipped
+		from
+		     accounts a
+		         join users u1 on u1.id = a.from_user
+		         join users u2 on u2.id = a.to_user
+		where
+		      chat_id = $1
+		  and
+		      (from_user = $2 or to_user = $2)`
+
+	var accounts []Account
+	if err := db.conn.SelectContext(ctx, &accounts, query, chatID, userID); err != nil {
+		return nil, fmt.Errorf("failed to get list of accounts for user %d: %v", userID, err)
+	}
+
+	return mergeDuplicateAccounts(accounts)
+}
+
+// GetAccountsInChat returns all accounts of chat ledger
+func (db Database) GetAccountsInChat(ctx context.Context, chatID int64) ([]Account, error) {
+	const query = `
+		select
+		       a.id,
+		       chat_id,
+		       from_user,
+		       coalesce(nullif(u1.name, ''), u1.display_name) from_user_name,
+		       to_user,
+		       coalesce(nullif(u2.name, ''), u2.display_name) to_user_name,
+		       balance,
+		       is_flipped
+		from
+		     accounts a
+		         join users u1 on u1.id = a.from_user
+		         join users u2 on u2.id = a.to_user
+		where
+		      chat_id = $1`
+
+	var accounts []Account
+	if err := db.conn.SelectContext(ctx, &accounts, query, chatID); err != nil {
+		return nil, fmt.Errorf("failed to get list of accounts for chat %d: %v", chatID, err)
+	}
+
+	return mergeDuplicateAccounts(accounts)
+}
+
+// logColumns are columns of transactionlog aliased as t which are selected to Log
+const logColumns = `
+		       id,
+		       operation_id,
+		       author_id,
+		       from_user,
+		       (select coalesce(nullif(name, ''), display_name) from users where id = from_user) as from_user_name,
+		       to_user,
+		       (select coalesce(nullif(name, ''), display_name) from users where id = to_user) as to_user_name,
+		       kind,
+		       balance_change,
+		       coalesce(original_amount, 0) as original_amount,
+		       coalesce(currency, '') as currency,
+		       coalesce(exchange_rate, 0) as exchange_rate,
+		       coalesce(rate_source, '') as rate_source,
+		       comment,
+		       coalesce(reverts, 0) as reverts,
+		       exists(select 1 from transactionlog r where r.chat_id = t.chat_id and r.reverts = t.operation_id) as reverted,
+		       exists(select 1 from transactionlog_edits e
+		              where e.chat_id = t.chat_id and e.operation_id = t.operation_id) as edited,
+		       exists(select 1 from disputes d
+		              where d.chat_id = t.chat_id and d.operation_id = t.operation_id and d.resolved_at is null) as disputed,
+		       ts,
+		       coalesce(effective_date, ts::date) as effective_date`
+
+// GetTransactionsForUser returns log records with given users in chat ledger
+func (db Database) GetTransactionsForUser(ctx context.Context, chatID int64, userID, page int) ([]Log, error) {
+	var logs []Log
+
+	if page < 1 {
+		return nil, fmt.Errorf("page number can not be less 1")
+	}
+
+	const offsetStep = 10
+	const query = `
+		select 
+		       ` + logColumns + `
+		from
+		     transactionlog t
+		where
+		      chat_id = $1
+		  and
+		      (from_user = $2 or to_user = $2)
+		  and
+		      not superseded
+		order by ts desc, id desc
+		limit 10
+		offset $3
+		`
+
+	if err := db.conn.SelectContext(ctx, &logs, query, chatID, userID, (page-1)*offsetStep); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// GetOperation returns log records of operation in chat ledger. Pending transfers of operation are returned
+// as records to users with zero ID and username in ToUserName.
+func (db Database) GetOperation(ctx context.Context, chatID, operationID int64) ([]Log, error) {
+	const query = `
+		select
+		       ` + logColumns + `
+		from
+		     transactionlog t
+		where
+		      chat_id = $1
+		  and
+		      operation_id = $2
+		  and
+		      not superseded
+		union all
+		select
+		       id,
+		       operation_id,
+		       author_id,
+		       from_user,
+		       (select coalesce(nullif(name, ''), display_name) from users where id = from_user) as from_user_name,
+		       0 as to_user,
+		       to_username as to_user_name,
+		       kind,
+		       amount as balance_change,
+		       coalesce(original_amount, 0) as original_amount,
+		       coalesce(currency, '') as currency,
+		       coalesce(exchange_rate, 0) as exchange_rate,
+		       coalesce(rate_source, '') as rate_source,
+		       comment,
+		       0 as reverts,
+		       false as reverted,
+		       exists(select 1 from transactionlog_edits e
+		              where e.chat_id = p.chat_id and e.operation_id = p.operation_id) as edited,
+		       exists(select 1 from disputes d
+		              where d.chat_id = p.chat_id and d.operation_id = p.operation_id and d.resolved_at is null) as disputed,
+		       created_at::timestamp as ts,
+		       coalesce(effective_date, created_at::date) as effective_date
+		from
+		     pending_transfers p
+		where
+		      chat_id = $1
+		  and
+		      operation_id = $2
+		order by id`
+
+	var logs []Log
+	if err := db.conn.SelectContext(ctx, &logs, query, chatID, operationID); err != nil {
+		return nil, fmt.Errorf("failed to get operation %d: %v", operationID, err)
+	}
+	if len(logs) == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrOperationNotFound, operationID)
+	}
+	return logs, nil
+}
+
+// GetLastOperationID returns ID of the latest operation of author in chat ledger which can be reverted.
+// Operations only to users who have not registered yet are found among pending transfers.
+func (db Database) GetLastOperationID(ctx context.Context, chatID int64, authorID int) (int64, error) {
+	const query = `
+		select
+		       operation_id
+		from
+		     transactionlog t
+		where
+		      chat_id = $1
+		  and
+		      author_id = $2
+		  and
+		      kind != $3
+		  and
+		      not superseded
+		  and
+		      not exists(select 1 from transactionlog r where r.chat_id = t.chat_id and r.reverts = t.operation_id)
+		union
+		select
+		       operation_id
+		from
+		     pending_transfers
+		where
+		      chat_id = $1
+		  and
+		      author_id = $2
+		  and
+		      kind != $3
+		order by operation_id desc
+		limit 1`
+
+	var operationID int64
+	err := db.conn.QueryRowxContext(ctx, query, chatID, authorID, KindRevert).Scan(&operationID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%w: no operations of user %d", ErrOperationNotFound, authorID)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last operation of user %d: %v", authorID, err)
+	}
+	return operationID, nil
+}
+
+// GetOperationByMessage returns ID of operation written by message in chat or of operation the message
+// is a reply of bot about. Operations to users who have not registered yet are found among pending transfers.
+// If message is linked to several operations, the latest one is returned.
+func (db Database) GetOperationByMessage(ctx context.Context, chatID int64, messageID int) (int64, error) {
+	const query = `
+		select
+		       operation_id
+		from
+		     transactionlog
+		where
+		      chat_id = $1
+		  and
+		      message_id = $2
+		union
+		select
+		       operation_id
+		from
+		     pending_transfers
+		where
+		      chat_id = $1
+		  and
+		      message_id = $2
+		union
+		select
+		       operation_id
+		from
+		     operation_messages
+		where
+		      chat_id = $1
+		  and
+		      message_id = $2
+		order by operation_id desc
+		limit 1`
+
+	var operationID int64
+	err := db.conn.QueryRowxContext(ctx, query, chatID, messageID).Scan(&operationID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%w: no operations of message %d", ErrOperationNotFound, messageID)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get operation of message %d: %v", messageID, err)
+	}
+	return operationID, nil
+}
+
+// AddOperationMessage stores ID of bot reply about operation, so the operation can be found by the reply
+func (db Database) AddOperationMessage(ctx context.Context, chatID int64, messageID int, operationID int64) error {
+	const query = `
+		insert into
+		    operation_messages (chat_id, message_id, operation_id)
+		values
+		    ($1, $2, $3)
+		on conflict (chat_id, message_id) do update set operation_id = excluded.operation_id`
+
+	if _, err := db.conn.ExecContext(ctx, query, chatID, messageID, operationID); err != nil {
+		return fmt.Errorf("failed to add message %d of operation %d: %v", messageID, operationID, err)
+	}
+	return nil
+}
+
+// SetConfirmDebts turns confirmation of new debts by debtors on or off in chat ledger
+func (db Database) SetConfirmDebts(ctx context.Context, chatID int64, enabled bool) error {
+	const query = `update ledgers set confirm_debts = $2 where chat_id = $1`
+
+	result, err := db.conn.ExecContext(ctx, query, chatID, enabled)
+	if err != nil {
+		return fmt.Errorf("failed to update ledger of chat %d: %v", chatID, err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("%w: %d", ErrLedgerNotFound, chatID)
+	}
+	return nil
+}
+
+// CreateProposal keeps operation with transfers until debtors confirm it, ID and debtors are stored to proposal.
+// Transfers to users who can't press buttons, i.e. pending and virtual ones, don't need confirmation.
+func (db Database) CreateProposal(ctx context.Context, proposal *Proposal, op Operation, transfers []Transfer) error {
+	tx, err := db.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer func() {
+		commitErr := tx.Commit()
+		if commitErr != nil {
+			log.Errorf("db.CreateProposal: failed to commit: %v", err)
+		}
+	}()
+
+	const proposalQuery = `
+		insert into debt_proposals
+		    (chat_id, author_id, description, comment, currency, exchange_rate, rate_source, effective_date, expires_at,
+		     source_message_id)
+		values
+		    ($1, $2, $3, $4, nullif($5, ''), nullif($6, 0), nullif($7, ''), $8, $9, nullif($10, 0))
+		returning id`
+
+	const transferQuery = `
+		insert into debt_proposal_transfers
+		    (proposal_id, from_user, to_user, to_username, amount, original_amount, needs_confirmation)
+		values
+		    ($1, $2, nullif($3, 0), $4, $5, $6, $7)`
+
+	var effectiveDate sql.NullTime
+	if !op.EffectiveDate.IsZero() {
+		effectiveDate.Time, effectiveDate.Valid = op.EffectiveDate, true
+	}
+
+	err = tx.QueryRowxContext(ctx, proposalQuery,
+		op.ChatID, op.AuthorID, proposal.Description, op.Comment, op.Currency, op.ExchangeRate, op.RateSource,
+		effectiveDate, proposal.ExpiresAt, op.MessageID).Scan(&proposal.ID)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("failed to rollback: %v", err)
+		}
+		return fmt.Errorf("failed to insert debt proposal: %v", err)
+	}
+
+	for _, transfer := range transfers {
+		account := transfer.Account
+		toUser := account.ToUser
+		if account.IsPending {
+			toUser = 0
+		}
+		_, err = tx.ExecContext(ctx, transferQuery,
+			proposal.ID, account.FromUser, toUser, account.ToUserName, transfer.Amount, transfer.OriginalAmount,
+			transfer.NeedsConfirmation())
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return fmt.Errorf("failed to rollback: %v", err)
+			}
+			return fmt.Errorf("failed to insert transfer of debt proposal: %v", err)
+		}
+	}
+
+	proposal.ChatID, proposal.AuthorID, proposal.Status = op.ChatID, op.AuthorID, ProposalOpen
+	if proposal.Debtors, err = proposalDebtors(ctx, tx, proposal.ID); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("failed to rollback: %v", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// SetProposalMessage stores ID of message with confirmation buttons of proposal
+func (db Database) SetProposalMessage(ctx context.Context, proposalID int64, messageID int) error {
+	const query = `update debt_proposals set message_id = $2 where id = $1`
+
+	if _, err := db.conn.ExecContext(ctx, query, proposalID, messageID); err != nil {
+		return fmt.Errorf("failed to set message of debt proposal %d: %v", proposalID, err)
+	}
+	return nil
+}
+
+// ConfirmProposal marks share of user in proposal as confirmed. When every debtor has confirmed, operation
+// of proposal is written to ledger and updated accounts are returned. ErrProposalClosed is returned with
+// proposal if it's not open anymore, proposal which is out of time is marked as expired.
+func (db Database) ConfirmProposal(ctx context.Context, chatID, proposalID int64, userID int) (Proposal, []Account, error) {
+	tx, err := db.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return Proposal{}, nil, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer func() {
+		commitErr := tx.Commit()
+		if commitErr != nil {
+			log.Errorf("db.ConfirmProposal: failed to commit: %v", err)
+		}
+	}()
+
+	const confirmQuery = `
+		update
+		    debt_proposal_transfers
+		set
+		    confirmed = true
+		where
+		    proposal_id = $1
+		  and
+		    to_user = $2
+		  and
+		    needs_confirmation`
+
+	const acceptQuery = `update debt_proposals set status = 'accepted', operation_id = $2 where id = $1`
+
+	// Message with buttons shows accepted debt, so it's a reply about the operation
+	const messageQuery = `
+		insert into
+		    operation_messages (chat_id, message_id, operation_id)
+		select
+		    chat_id, message_id, $2
+		from
+		    debt_proposals
+		where
+		    id = $1
+		  and
+		    message_id != 0
+		on conflict (chat_id, message_id) do nothing`
+
+	proposal, err := openProposal(ctx, tx, chatID, proposalID)
+	if errors.Is(err, ErrProposalClosed) {
+		// Proposal can be just marked as expired, so transaction is committed
+		return proposal, nil, err
+	}
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return Proposal{}, nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return Proposal{}, nil, err
+	}
+
+	result, err := tx.ExecContext(ctx, confirmQuery, proposalID, userID)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return Proposal{}, nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return Proposal{}, nil, fmt.Errorf("failed to confirm debt proposal %d: %v", proposalID, err)
+	}
+	if affected, affectedErr := result.RowsAffected(); affectedErr == nil && affected == 0 {
+		err = fmt.Errorf("%w: %d", ErrNotDebtor, userID)
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return Proposal{}, nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return proposal, nil, err
+	}
+
+	if proposal.Debtors, err = proposalDebtors(ctx, tx, proposalID); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return Proposal{}, nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return Proposal{}, nil, err
+	}
+	for _, debtor := range proposal.Debtors {
+		if !debtor.Confirmed {
+			return proposal, nil, nil
+		}
+	}
+
+	op, transfers, err := proposalOperation(ctx, tx, proposalID)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return Proposal{}, nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return Proposal{}, nil, err
+	}
+	if err = newOperation(ctx, tx, &op); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return Proposal{}, nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return Proposal{}, nil, err
+	}
+	accounts, err := db.applyTransfers(ctx, tx, &op, transfers)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return Proposal{}, nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return Proposal{}, nil, err
+	}
+	if _, err = tx.ExecContext(ctx, acceptQuery, proposalID, op.ID); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return Proposal{}, nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return Proposal{}, nil, fmt.Errorf("failed to accept debt proposal %d: %v", proposalID, err)
+	}
+	if _, err = tx.ExecContext(ctx, messageQuery, proposalID, op.ID); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return Proposal{}, nil, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return Proposal{}, nil, fmt.Errorf("failed to add message of debt proposal %d: %v", proposalID, err)
+	}
+
+	proposal.Status = ProposalAccepted
+	proposal.OperationID = sql.NullInt64{Int64: op.ID, Valid: true}
+	return proposal, accounts, nil
+}
+
+// DeclineProposal closes proposal without changing balances, it can be done by any of debtors or by author.
+// ErrProposalClosed is returned with proposal if it's not open anymore.
+func (db Database) DeclineProposal(ctx context.Context, chatID, proposalID int64, userID int) (Proposal, error) {
+	tx, err := db.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return Proposal{}, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer func() {
+		commitErr := tx.Commit()
+		if commitErr != nil {
+			log.Errorf("db.DeclineProposal: failed to commit: %v", err)
+		}
+	}()
+
+	const declineQuery = `update debt_proposals set status = 'declined' where id = $1`
+
+	proposal, err := openProposal(ctx, tx, chatID, proposalID)
+	if errors.Is(err, ErrProposalClosed) {
+		return proposal, err
+	}
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return Proposal{}, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return Proposal{}, err
+	}
+
+	allowed := proposal.AuthorID == userID
+	for _, debtor := range proposal.Debtors {
+		if debtor.UserID == userID {
+			allowed = true
+		}
+	}
+	if !allowed {
+		err = fmt.Errorf("%w: %d", ErrNotDebtor, userID)
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return Proposal{}, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return proposal, err
+	}
+
+	if _, err = tx.ExecContext(ctx, declineQuery, proposalID); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return Proposal{}, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return Proposal{}, fmt.Errorf("failed to decline debt proposal %d: %v", proposalID, err)
+	}
+	proposal.Status = ProposalDeclined
+	return proposal, nil
+}
+
+// ExpireProposals marks open proposals which are out of time as expired and returns them
+func (db Database) ExpireProposals(ctx context.Context) ([]Proposal, error) {
+	const query = `
+		update
+		    debt_proposals
+		set
+		    status = 'expired'
+		where
+		    status = 'open'
+		  and
+		    expires_at <= now()
+		returning id, chat_id, author_id, message_id, description, status, operation_id, expires_at`
+
+	var proposals []Proposal
+	if err := db.conn.SelectContext(ctx, &proposals, query); err != nil {
+		return nil, fmt.Errorf("failed to expire debt proposals: %v", err)
+	}
+	for i := range proposals {
+		var err error
+		if proposals[i].Debtors, err = proposalDebtors(ctx, db.conn, proposals[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return proposals, nil
+}
+
+// openProposal locks proposal of chat for update. If proposal is not open ErrProposalClosed is returned with it,
+// open proposal which is out of time is marked as expired first.
+func openProposal(ctx context.Context, tx *sqlx.Tx, chatID, proposalID int64) (Proposal, error) {
+	const lockQuery = `
+		select
+		    id, chat_id, author_id, message_id, description, status, operation_id, expires_at
+		from
+		    debt_proposals
+		where
+		    id = $1
+		  and
+		    chat_id = $2
+		for update`
+
+	const expireQuery = `update debt_proposals set status = 'expired' where id = $1`
+
+	var proposal Proposal
+	err := tx.GetContext(ctx, &proposal, lockQuery, proposalID, chatID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Proposal{}, fmt.Errorf("%w: %d", ErrProposalNotFound, proposalID)
+	}
 	if err != nil {
-		return nil, err
+		return Proposal{}, fmt.Errorf("failed to get debt proposal %d: %v", proposalID, err)
 	}
-	return &Database{
-		conn: conn,
-	}, nil
+	if proposal.Debtors, err = proposalDebtors(ctx, tx, proposalID); err != nil {
+		return Proposal{}, err
+	}
+
+	if proposal.Status == ProposalOpen && !proposal.ExpiresAt.After(time.Now()) {
+		if _, err = tx.ExecContext(ctx, expireQuery, proposalID); err != nil {
+			return Proposal{}, fmt.Errorf("failed to expire debt proposal %d: %v", proposalID, err)
+		}
+		proposal.Status = ProposalExpired
+	}
+	if proposal.Status != ProposalOpen {
+		return proposal, fmt.Errorf("%w: %d is %s", ErrProposalClosed, proposalID, proposal.Status)
+	}
+	return proposal, nil
+}
+
+// proposalDebtors returns users who have to confirm proposal
+func proposalDebtors(ctx context.Context, q sqlx.QueryerContext, proposalID int64) ([]Debtor, error) {
+	const query = `
+		select
+		    t.to_user user_id,
+		    coalesce(nullif(u.name, ''), u.display_name) "name",
+		    sum(t.amount) amount,
+		    bool_and(t.confirmed) confirmed
+		from
+		    debt_proposal_transfers t
+		        join users u on u.id = t.to_user
+		where
+		    t.proposal_id = $1
+		  and
+		    t.needs_confirmation
+		group by
+		    t.to_user, u.name, u.display_name
+		order by
+		    "name"`
+
+	var debtors []Debtor
+	if err := sqlx.SelectContext(ctx, q, &debtors, query, proposalID); err != nil {
+		return nil, fmt.Errorf("failed to get debtors of debt proposal %d: %v", proposalID, err)
+	}
+	return debtors, nil
+}
+
+// proposalOperation restores operation and transfers of proposal. Users who were not registered when
+// debt was proposed can be registered by now, their transfers go to accounts instead of pending ones.
+func proposalOperation(ctx context.Context, tx *sqlx.Tx, proposalID int64) (Operation, []Transfer, error) {
+	const operationQuery = `
+		select
+		    chat_id,
+		    author_id,
+		    comment,
+		    coalesce(currency, '') currency,
+		    coalesce(exchange_rate, 0) exchange_rate,
+		    coalesce(rate_source, '') rate_source,
+		    effective_date,
+		    coalesce(source_message_id, 0) source_message_id
+		from
+		    debt_proposals
+		where
+		    id = $1`
+
+	const transfersQuery = `
+		select
+		    from_user, coalesce(to_user, 0) to_user, to_username, amount, original_amount
+		from
+		    debt_proposal_transfers
+		where
+		    proposal_id = $1`
+
+	const registeredQuery = `
+		select
+		    to_user
+		from
+		    accounts
+		where
+		    chat_id = $1
+		  and
+		    from_user = $2
+		  and
+		    to_user in (select coalesce(linked_to, id) from users where lower(name) = lower($3))`
+
+	var (
+		row struct {
+			ChatID        int64        `db:"chat_id"`
+			AuthorID      int          `db:"author_id"`
+			Comment       string       `db:"comment"`
+			Currency      string       `db:"currency"`
+			ExchangeRate  float64      `db:"exchange_rate"`
+			RateSource    string       `db:"rate_source"`
+			EffectiveDate sql.NullTime `db:"effective_date"`
+			MessageID     int          `db:"source_message_id"`
+		}
+		rows []struct {
+			FromUser       int          `db:"from_user"`
+			ToUser         int          `db:"to_user"`
+			ToUsername     string       `db:"to_username"`
+			Amount         money.Amount `db:"amount"`
+			OriginalAmount money.Amount `db:"original_amount"`
+		}
+	)
+	if err := tx.GetContext(ctx, &row, operationQuery, proposalID); err != nil {
+		return Operation{}, nil, fmt.Errorf("failed to get operation of debt proposal %d: %v", proposalID, err)
+	}
+	if err := tx.SelectContext(ctx, &rows, transfersQuery, proposalID); err != nil {
+		return Operation{}, nil, fmt.Errorf("failed to get transfers of debt proposal %d: %v", proposalID, err)
+	}
+
+	op := Operation{
+		ChatID:       row.ChatID,
+		AuthorID:     row.AuthorID,
+		Kind:         KindExpense,
+		Comment:      row.Comment,
+		Currency:     row.Currency,
+		ExchangeRate: row.ExchangeRate,
+		RateSource:   row.RateSource,
+		MessageID:    row.MessageID,
+	}
+	if row.EffectiveDate.Valid {
+		op.EffectiveDate = row.EffectiveDate.Time
+	}
+
+	transfers := make([]Transfer, 0, len(rows))
+	for _, r := range rows {
+		account := Account{ChatID: row.ChatID, FromUser: r.FromUser, ToUser: r.ToUser}
+		if r.ToUser == 0 {
+			err := tx.QueryRowxContext(ctx, registeredQuery, row.ChatID, r.FromUser, r.ToUsername).Scan(&account.ToUser)
+			if errors.Is(err, sql.ErrNoRows) {
+				account.ToUserName, account.IsPending = r.ToUsername, true
+			} else if err != nil {
+				return Operation{}, nil, fmt.Errorf("failed to get user by name %s: %v", r.ToUsername, err)
+			}
+		}
+		transfers = append(transfers, Transfer{Account: account, Amount: r.Amount, OriginalAmount: r.OriginalAmount})
+	}
+	return op, transfers, nil
 }
 
-// CreateUser creates new User in database
-func (db Database) CreateUser(ctx context.Context, id int, name string) error {
+// DisputeOperation adds objection of user to thread of open dispute on operation, dispute is opened if there is
+// none. Only author and users of operation can dispute it, ErrNotParty is returned for others.
+func (db Database) DisputeOperation(
+	ctx context.Context, chatID, operationID int64, userID int, reason string,
+) (Dispute, error) {
 	tx, err := db.conn.BeginTxx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to start transaction: %v", err)
+		return Dispute{}, fmt.Errorf("failed to start transaction: %v", err)
 	}
 	defer func() {
 		commitErr := tx.Commit()
 		if commitErr != nil {
-			log.Errorf("failed to commit: %v", err)
+			log.Errorf("db.DisputeOperation: failed to commit: %v", err)
 		}
 	}()
 
-	const createUserQuery = "insert into users (id, name) values ($1, $2)"
-	_, err = tx.ExecContext(ctx, createUserQuery, id, name)
+	const revertedQuery = `select exists(select 1 from transactionlog where chat_id = $1 and reverts = $2)`
+
+	const openQuery = `
+		select
+		    id
+		from
+		    disputes
+		where
+		    chat_id = $1
+		  and
+		    operation_id = $2
+		  and
+		    resolved_at is null
+		for update`
+
+	const createQuery = `insert into disputes (chat_id, operation_id, opened_by) values ($1, $2, $3) returning id`
+
+	parties, err := operationParties(ctx, tx, chatID, operationID)
 	if err != nil {
 		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			return fmt.Errorf("failed to rollback: %v", err)
+			return Dispute{}, fmt.Errorf("failed to rollback: %v", err)
 		}
-		return fmt.Errorf("failed to create user: %v", err)
+		return Dispute{}, err
+	}
+	if len(parties) == 0 {
+		err = fmt.Errorf("%w: %d", ErrOperationNotFound, operationID)
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return Dispute{}, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return Dispute{}, err
 	}
 
-	const createAccountsQuery = `
-		insert into
-		    accounts (from_user, to_user, is_flipped) 
-		    select $1 to_user, id from_user, false from users where id != $1
-		    union
-		    select id to_user, $1 from_user, true from users where id != $1`
-	_, err = tx.ExecContext(ctx, createAccountsQuery, id)
+	var isParty bool
+	for _, party := range parties {
+		isParty = isParty || party.ID == userID
+	}
+	if !isParty {
+		err = fmt.Errorf("%w: %d", ErrNotParty, userID)
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return Dispute{}, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return Dispute{}, err
+	}
+
+	var reverted bool
+	if err = tx.QueryRowxContext(ctx, revertedQuery, chatID, operationID).Scan(&reverted); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return Dispute{}, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return Dispute{}, fmt.Errorf("failed to check if operation %d is reverted: %v", operationID, err)
+	}
+	if reverted {
+		err = fmt.Errorf("%w: %d", ErrAlreadyReverted, operationID)
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return Dispute{}, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return Dispute{}, err
+	}
+
+	var disputeID int64
+	err = tx.QueryRowxContext(ctx, openQuery, chatID, operationID).Scan(&disputeID)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = tx.QueryRowxContext(ctx, createQuery, chatID, operationID, userID).Scan(&disputeID)
+	}
 	if err != nil {
 		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			return fmt.Errorf("failed to rollback: %v", err)
+			return Dispute{}, fmt.Errorf("failed to rollback: %v", err)
 		}
-		return fmt.Errorf("failed to create accounts: %v", err)
+		return Dispute{}, fmt.Errorf("failed to open dispute on operation %d: %v", operationID, err)
 	}
-	return nil
+
+	if err = addDisputeComment(ctx, tx, disputeID, userID, reason); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return Dispute{}, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return Dispute{}, err
+	}
+
+	dispute, err := loadDispute(ctx, tx, disputeID)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return Dispute{}, fmt.Errorf("failed to rollback: %v", err)
+		}
+		return Dispute{}, err
+	}
+	dispute.Parties = parties
+	return dispute, nil
+}
+
+// GetDispute returns open dispute on operation or the last resolved one, ErrDisputeNotFound is returned
+// if operation was never disputed
+func (db Database) GetDispute(ctx context.Context, chatID, operationID int64) (Dispute, error) {
+	const query = `
+		select
+		    id
+		from
+		    disputes
+		where
+		    chat_id = $1
+		  and
+		    operation_id = $2
+		order by resolved_at is null desc, id desc
+		limit 1`
+
+	var disputeID int64
+	err := db.conn.QueryRowxContext(ctx, query, chatID, operationID).Scan(&disputeID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Dispute{}, fmt.Errorf("%w: %d", ErrDisputeNotFound, operationID)
+	}
+	if err != nil {
+		return Dispute{}, fmt.Errorf("failed to get dispute on operation %d: %v", operationID, err)
+	}
+
+	dispute, err := loadDispute(ctx, db.conn, disputeID)
+	if err != nil {
+		return Dispute{}, err
+	}
+	if dispute.Parties, err = operationParties(ctx, db.conn, chatID, operationID); err != nil {
+		return Dispute{}, err
+	}
+	return dispute, nil
 }
 
-// UpdateAccounts updates accounts from one user to multiple users
-func (db Database) UpdateAccounts(ctx context.Context, toAccounts []Account, amount int, comment string) ([]Account, error) {
+// ResolveDispute closes open dispute on operation, comment is added to its thread if it's not empty.
+// ErrDisputeNotFound is returned if operation has no open dispute.
+func (db Database) ResolveDispute(
+	ctx context.Context, chatID, operationID int64, userID int, resolution Resolution, comment string,
+) (Dispute, error) {
 	tx, err := db.conn.BeginTxx(ctx, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to start transaction: %v", err)
+		return Dispute{}, fmt.Errorf("failed to start transaction: %v", err)
 	}
 	defer func() {
 		commitErr := tx.Commit()
 		if commitErr != nil {
-			log.Errorf("db.UpdateAccounts: failed to commit: %v", err)
+			log.Errorf("db.ResolveDispute: failed to commit: %v", err)
 		}
 	}()
-	var accounts []Account
 
-	if len(toAccounts) > 1 {
-		partsPlusAuthor := len(toAccounts) + 1
-		amount = int(math.Round(float64(amount) / float64(partsPlusAuthor)))
+	disputeID, err := resolveDispute(ctx, tx, chatID, operationID, userID, resolution)
+	if err == nil && disputeID == 0 {
+		err = fmt.Errorf("%w: %d", ErrDisputeNotFound, operationID)
 	}
-
-	const balanceQuery = `
-		update
-			accounts
-		set
-		    balance = case when from_user = $2 then balance + $1 else balance end
-		where
-		    (from_user = $3 and to_user = $2) or (from_user = $2 and to_user = $3)
-		returning from_user, to_user, balance, is_flipped`
-
-	const logQuery = `insert into transactionlog (from_user, to_user, balance_change, comment) values ($1, $2, $3, $4)`
-
-	for _, toAccount := range toAccounts {
-		var updatedAccounts []Account
-
-		err = tx.SelectContext(ctx, &updatedAccounts, balanceQuery, amount, toAccount.FromUser, toAccount.ToUser)
-		if err != nil {
-			if rollbackErr := tx.Rollback(); rollbackErr != nil {
-				return nil, fmt.Errorf("failed to rollback: %v", err)
-			}
-			return nil, fmt.Errorf("failed to update balance: %v", err)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return Dispute{}, fmt.Errorf("failed to rollback: %v", err)
 		}
+		return Dispute{}, err
+	}
 
-		if _, err = tx.ExecContext(ctx, logQuery, toAccount.FromUser, toAccount.ToUser, amount, comment); err != nil {
+	if comment != "" {
+		if err = addDisputeComment(ctx, tx, disputeID, userID, comment); err != nil {
 			if rollbackErr := tx.Rollback(); rollbackErr != nil {
-				return nil, fmt.Errorf("failed to rollback: %v", err)
+				return Dispute{}, fmt.Errorf("failed to rollback: %v", err)
 			}
-			return nil, fmt.Errorf("failed to insert log record: %v", err)
+			return Dispute{}, err
 		}
+	}
 
-		updatedAccounts = mergeDuplicateAccounts(updatedAccounts)
-		if len(updatedAccounts) > 1 {
-			return nil, fmt.Errorf("updated accounts after merging still not 1: %d", len(updatedAccounts))
-		}
-		account := updatedAccounts[0]
-		(&account).FromUserName, err = db.userIDtoName(ctx, account.FromUser)
-		if err != nil {
-			if rollbackErr := tx.Rollback(); rollbackErr != nil {
-				return nil, fmt.Errorf("failed to rollback: %v", err)
-			}
-			return nil, fmt.Errorf("failed to resolve FromUser name by id: %v", err)
+	dispute, err := loadDispute(ctx, tx, disputeID)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return Dispute{}, fmt.Errorf("failed to rollback: %v", err)
 		}
-		(&account).ToUserName, err = db.userIDtoName(ctx, account.ToUser)
-		if err != nil {
-			if rollbackErr := tx.Rollback(); rollbackErr != nil {
-				return nil, fmt.Errorf("failed to rollback: %v", err)
-			}
-			return nil, fmt.Errorf("failed to resolve ToUser name by id: %v", err)
+		return Dispute{}, err
+	}
+	if dispute.Parties, err = operationParties(ctx, tx, chatID, operationID); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return Dispute{}, fmt.Errorf("failed to rollback: %v", err)
 		}
-		accounts = append(accounts, account)
+		return Dispute{}, err
 	}
-	return accounts, nil
+	return dispute, nil
 }
 
-// UserNameToAccount gets user ID from username
-func (db Database) UserNameToAccount(ctx context.Context, fromUserID int, toUsername string) (Account, error) {
+// resolveDispute closes open dispute on operation and returns its ID, zero is returned if there is no open dispute
+func resolveDispute(
+	ctx context.Context, tx *sqlx.Tx, chatID, operationID int64, userID int, resolution Resolution,
+) (int64, error) {
 	const query = `
-		select 
-		       is_flipped, to_user
-		from 
-		     accounts
-		where 
-		      from_user = $1
-		  and 
-		      to_user = (select id from users where name = $2)`
+		update
+		    disputes
+		set
+		    resolved_by = $3,
+		    resolution = $4,
+		    resolved_at = now()
+		where
+		    chat_id = $1
+		  and
+		    operation_id = $2
+		  and
+		    resolved_at is null
+		returning id`
 
-	var (
-		isFlipped bool
-		userID    int
-	)
-	if err := db.conn.QueryRowxContext(ctx, query, fromUserID, toUsername).Scan(&isFlipped, &userID); err != nil {
-		return Account{}, fmt.Errorf("failed to get user by name: %v", err)
+	var disputeID int64
+	err := tx.QueryRowxContext(ctx, query, chatID, operationID, userID, resolution).Scan(&disputeID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve dispute on operation %d: %v", operationID, err)
 	}
-	return Account{FromUser: fromUserID, ToUser: userID, IsFlipped: isFlipped}, nil
+	return disputeID, nil
 }
 
-func (db Database) userIDtoName(ctx context.Context, userID int) (string, error) {
-	const query = `select name from users where id = $1`
+// addDisputeComment adds comment of user to thread of dispute
+func addDisputeComment(ctx context.Context, tx *sqlx.Tx, disputeID int64, userID int, text string) error {
+	const query = `insert into dispute_comments (dispute_id, author_id, text) values ($1, $2, $3)`
 
-	var name string
-	if err := db.conn.QueryRowxContext(ctx, query, userID).Scan(&name); err != nil {
-		return "", fmt.Errorf("failed to get user by id: %v", err)
+	if _, err := tx.ExecContext(ctx, query, disputeID, userID, text); err != nil {
+		return fmt.Errorf("failed to add comment to dispute %d: %v", disputeID, err)
 	}
-	return name, nil
+	return nil
+}
+
+// loadDispute returns dispute with its comments
+func loadDispute(ctx context.Context, q sqlx.QueryerContext, disputeID int64) (Dispute, error) {
+	const disputeQuery = `
+		select
+		    id, chat_id, operation_id, opened_by,
+		    coalesce(resolved_by, 0) resolved_by,
+		    coalesce(resolution, '') resolution,
+		    created_at, resolved_at
+		from
+		    disputes
+		where
+		    id = $1`
+
+	const commentsQuery = `
+		select
+		    c.author_id,
+		    coalesce(nullif(u.name, ''), u.display_name) author_name,
+		    c.text,
+		    c.created_at
+		from
+		    dispute_comments c
+		        join users u on u.id = c.author_id
+		where
+		    c.dispute_id = $1
+		order by c.id`
+
+	var dispute Dispute
+	if err := sqlx.GetContext(ctx, q, &dispute, disputeQuery, disputeID); err != nil {
+		return Dispute{}, fmt.Errorf("failed to get dispute %d: %v", disputeID, err)
+	}
+	if err := sqlx.SelectContext(ctx, q, &dispute.Comments, commentsQuery, disputeID); err != nil {
+		return Dispute{}, fmt.Errorf("failed to get comments of dispute %d: %v", disputeID, err)
+	}
+	return dispute, nil
 }
 
-// GetAccountsWithUser returns accounts connected to user
-func (db Database) GetAccountsWithUser(ctx context.Context, userID int) ([]Account, error) {
+// operationParties returns author and users of operation, it's empty if there is no such operation.
+// Targets of pending transfers are users with their username if bot knows them.
+func operationParties(ctx context.Context, q sqlx.QueryerContext, chatID, operationID int64) ([]User, error) {
 	const query = `
 		select
-		       a.id,
-		       from_user,
-		       u1.name from_user_name,
-		       to_user,
-		       u2.name to_user_name,
-		       balance,
-		       is_flipped
+		    id, name, display_name, is_virtual
 		from
-		     accounts a
-		         join users u1 on u1.id = a.from_user
-		         join users u2 on u2.id = a.to_user
+		    users
 		where
-		      from_user = $1
-		   or
-		      to_user = $1`
+		    id in (
+		        select author_id from transactionlog where chat_id = $1 and operation_id = $2 and not superseded
+		        union
+		        select from_user from transactionlog where chat_id = $1 and operation_id = $2 and not superseded
+		        union
+		        select to_user from transactionlog where chat_id = $1 and operation_id = $2 and not superseded
+		        union
+		        select author_id from pending_transfers where chat_id = $1 and operation_id = $2
+		        union
+		        select from_user from pending_transfers where chat_id = $1 and operation_id = $2
+		        union
+		        select u.id from users u join pending_transfers p on lower(u.name) = lower(p.to_username)
+		        where p.chat_id = $1 and p.operation_id = $2 and not u.is_virtual
+		    )
+		order by id`
 
-	var accounts []Account
-	if err := db.conn.SelectContext(ctx, &accounts, query, userID); err != nil {
-		return nil, fmt.Errorf("failed to get list of accounts for user %d: %v", userID, err)
+	var users []User
+	if err := sqlx.SelectContext(ctx, q, &users, query, chatID, operationID); err != nil {
+		return nil, fmt.Errorf("failed to get users of operation %d: %v", operationID, err)
 	}
+	return users, nil
+}
 
-	accounts = mergeDuplicateAccounts(accounts)
+// GetExchangeRates returns all cached exchange rates
+func (db Database) GetExchangeRates(ctx context.Context) ([]ExchangeRate, error) {
+	const query = `select from_currency, to_currency, rate, source, updated_at, expires_at from exchange_rates`
 
-	return accounts, nil
+	var exchangeRates []ExchangeRate
+	if err := db.conn.SelectContext(ctx, &exchangeRates, query); err != nil {
+		return nil, fmt.Errorf("failed to get exchange rates: %v", err)
+	}
+	return exchangeRates, nil
 }
 
-// GetTransactionsForUser returns log records with given users
-func (db Database) GetTransactionsForUser(ctx context.Context, userID, page int) ([]Log, error) {
-	var logs []Log
+// SaveExchangeRate creates or replaces cached exchange rate
+func (db Database) SaveExchangeRate(ctx context.Context, rate ExchangeRate) error {
+	const query = `
+		insert into
+		    exchange_rates (from_currency, to_currency, rate, source, updated_at, expires_at)
+		values
+		    (:from_currency, :to_currency, :rate, :source, :updated_at, :expires_at)
+		on conflict (from_currency, to_currency) do update set
+		    rate = excluded.rate,
+		    source = excluded.source,
+		    updated_at = excluded.updated_at,
+		    expires_at = excluded.expires_at`
 
-	if page < 1 {
-		return nil, fmt.Errorf("page number can not be less 1")
+	if _, err := db.conn.NamedExecContext(ctx, query, rate); err != nil {
+		return fmt.Errorf("failed to save exchange rate %s/%s: %v", rate.FromCurrency, rate.ToCurrency, err)
 	}
+	return nil
+}
 
-	const offsetStep = 10
+// PinRate creates or replaces pinned rate of currency in chat
+func (db Database) PinRate(ctx context.Context, rate PinnedRate) error {
 	const query = `
-		select 
-		       (select name from users where id = from_user) as from_user_name,
-		       (select name from users where id = to_user) as to_user_name,
-		       balance_change,
-		       comment,
-		       ts
+		insert into
+		    pinned_rates (chat_id, currency, base_currency, rate, author_id, expires_at)
+		values
+		    (:chat_id, :currency, :base_currency, :rate, :author_id, :expires_at)
+		on conflict (chat_id, currency) do update set
+		    base_currency = excluded.base_currency,
+		    rate = excluded.rate,
+		    author_id = excluded.author_id,
+		    created_at = now(),
+		    expires_at = excluded.expires_at`
+
+	if _, err := db.conn.NamedExecContext(ctx, query, rate); err != nil {
+		return fmt.Errorf("failed to pin rate of %s: %v", rate.Currency, err)
+	}
+	return nil
+}
+
+// UnpinRate removes pinned rate of currency in chat, ErrPinnedRateNotFound is returned if there was none
+func (db Database) UnpinRate(ctx context.Context, chatID int64, currency string) error {
+	const query = `delete from pinned_rates where chat_id = $1 and currency = $2`
+
+	result, err := db.conn.ExecContext(ctx, query, chatID, currency)
+	if err != nil {
+		return fmt.Errorf("failed to unpin rate of %s: %v", currency, err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("%w: %s", ErrPinnedRateNotFound, currency)
+	}
+	return nil
+}
+
+// GetPinnedRates returns not expired pinned rates of chat
+func (db Database) GetPinnedRates(ctx context.Context, chatID int64) ([]PinnedRate, error) {
+	const query = `
+		select
+		       chat_id, currency, base_currency, rate, author_id, created_at, expires_at
 		from
-		     transactionlog
+		     pinned_rates
 		where
-		      from_user = $1 or to_user = $1
-		order by ts desc
-		limit 10
-		offset $2
-		`
+		      chat_id = $1
+		  and
+		      (expires_at is null or expires_at > now())
+		order by currency`
 
-	if err := db.conn.SelectContext(ctx, &logs, query, userID, (page-1)*offsetStep); err != nil {
-		return nil, err
+	var rates []PinnedRate
+	if err := db.conn.SelectContext(ctx, &rates, query, chatID); err != nil {
+		return nil, fmt.Errorf("failed to get pinned rates of chat %d: %v", chatID, err)
 	}
-	return logs, nil
+	return rates, nil
+}
+
+// GetUserSettings returns settings of user, defaults are returned if user has not changed anything
+func (db Database) GetUserSettings(ctx context.Context, userID int) (UserSettings, error) {
+	const query = `select user_id, display_currency from user_settings where user_id = $1`
+
+	var settings UserSettings
+	err := db.conn.GetContext(ctx, &settings, query, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return UserSettings{UserID: userID}, nil
+	}
+	if err != nil {
+		return UserSettings{}, fmt.Errorf("failed to get settings of user %d: %v", userID, err)
+	}
+	return settings, nil
+}
+
+// SaveUserSettings creates or replaces settings of user
+func (db Database) SaveUserSettings(ctx context.Context, settings UserSettings) error {
+	const query = `
+		insert into
+		    user_settings (user_id, display_currency)
+		values
+		    (:user_id, :display_currency)
+		on conflict (user_id) do update set
+		    display_currency = excluded.display_currency`
+
+	if _, err := db.conn.NamedExecContext(ctx, query, settings); err != nil {
+		return fmt.Errorf("failed to save settings of user %d: %v", settings.UserID, err)
+	}
+	return nil
 }
 
 // mergeDuplicateAccounts is needed because we store two records for single user-to-user relation.
 // It puts accounts in hash map with key as sorted user IDs and sums balances in same pairs.
-func mergeDuplicateAccounts(accounts []Account) (resultAccounts []Account) {
+func mergeDuplicateAccounts(accounts []Account) (resultAccounts []Account, err error) {
 	hashMap := make(map[string]*Account)
 
 	for i, account := range accounts {
@@ -244,11 +2755,16 @@ func mergeDuplicateAccounts(accounts []Account) (resultAccounts []Account) {
 	for _, account := range accounts {
 		if account.IsFlipped == true {
 			key := account.String()
-			hashMap[key].Balance += account.Balance * -1
+			if _, ok := hashMap[key]; !ok {
+				return nil, fmt.Errorf("account %s has no pair for flipped record", key)
+			}
+			if hashMap[key].Balance, err = hashMap[key].Balance.Sub(account.Balance); err != nil {
+				return nil, fmt.Errorf("failed to merge balances of account %s: %w", key, err)
+			}
 		}
 	}
 	for _, account := range hashMap {
 		resultAccounts = append(resultAccounts, *account)
 	}
-	return resultAccounts
+	return resultAccounts, nil
 }
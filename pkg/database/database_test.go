@@ -1,6 +1,8 @@
 package database
 
 import (
+	"errors"
+	"moneyjar/pkg/money"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -14,25 +16,163 @@ func Test_mergeDuplicateAccounts(t *testing.T) {
 		name               string
 		args               args
 		wantResultAccounts []Account
+		wantErr            bool
 	}{
 		{
 			name: "default",
 			args: args{
 				accounts: []Account{
-					{FromUser: 1, FromUserName: "user1", ToUser: 2, ToUserName: "user2", Balance: 100},
-					{FromUser: 2, FromUserName: "user2", ToUser: 1, ToUserName: "user1", Balance: 50, IsFlipped: true},
+					{FromUser: 1, FromUserName: "user1", ToUser: 2, ToUserName: "user2", Balance: money.New(100, 2)},
+					{FromUser: 2, FromUserName: "user2", ToUser: 1, ToUserName: "user1", Balance: money.New(50, 2), IsFlipped: true},
 				},
 			},
 			wantResultAccounts: []Account{
-				{FromUser: 1, FromUserName: "user1", ToUser: 2, ToUserName: "user2", Balance: 50},
+				{FromUser: 1, FromUserName: "user1", ToUser: 2, ToUserName: "user2", Balance: money.New(50, 2)},
 			},
 		},
+		{
+			name: "flipped record without pair",
+			args: args{
+				accounts: []Account{
+					{FromUser: 2, FromUserName: "user2", ToUser: 1, ToUserName: "user1", Balance: money.New(50, 2), IsFlipped: true},
+				},
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if gotResultAccounts := mergeDuplicateAccounts(tt.args.accounts); !assert.EqualValues(t, tt.wantResultAccounts, gotResultAccounts) {
+			gotResultAccounts, err := mergeDuplicateAccounts(tt.args.accounts)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			if !assert.EqualValues(t, tt.wantResultAccounts, gotResultAccounts) {
 				t.Errorf("mergeDuplicateAccounts() = %v, want %v", gotResultAccounts, tt.wantResultAccounts)
 			}
 		})
 	}
 }
+
+func Test_checkRevertible(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []Log
+		wantErr error
+	}{
+		{
+			name: "expense",
+			records: []Log{
+				{FromUser: 1, ToUser: 2, Kind: KindExpense, BalanceChange: money.New(100, 2)},
+				{FromUser: 1, ToUser: 3, Kind: KindExpense, BalanceChange: money.New(100, 2)},
+			},
+		},
+		{
+			name:    "pending only",
+			records: nil,
+		},
+		{
+			name: "revert",
+			records: []Log{
+				{FromUser: 1, ToUser: 2, Kind: KindRevert, BalanceChange: money.New(-100, 2), Reverts: 5},
+			},
+			wantErr: ErrRevertOfRevert,
+		},
+		{
+			name: "record of revert with operation kind",
+			records: []Log{
+				{FromUser: 1, ToUser: 2, Kind: KindSettlement, BalanceChange: money.New(-100, 2), Reverts: 5},
+			},
+			wantErr: ErrRevertOfRevert,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkRevertible(7, tt.records)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.True(t, errors.Is(err, tt.wantErr), "checkRevertible() error = %v, want %v", err, tt.wantErr)
+		})
+	}
+}
+
+func Test_linkRecords(t *testing.T) {
+	const (
+		virtualID = -1
+		userID    = 1
+		otherID   = 2
+	)
+	records := []Log{
+		// Operation 10 is a debt of the author to virtual member and to other user split in one /debt
+		{ID: 11, OperationID: 10, FromUser: userID, ToUser: virtualID, Kind: KindExpense, BalanceChange: money.New(100, 2)},
+		{ID: 12, OperationID: 10, FromUser: otherID, ToUser: virtualID, Kind: KindExpense, BalanceChange: money.New(50, 2)},
+		// Operation 13 is a debt between virtual member and the user only
+		{ID: 14, OperationID: 13, FromUser: virtualID, ToUser: userID, Kind: KindExpense, BalanceChange: money.New(30, 2)},
+	}
+
+	relinked, dropped := linkRecords(records, virtualID, userID)
+	assert.Equal(t, []Log{
+		{ID: 12, OperationID: 10, FromUser: otherID, ToUser: userID, Kind: KindExpense, BalanceChange: money.New(50, 2)},
+	}, relinked)
+	assert.Equal(t, []Log{records[0], records[2]}, dropped)
+
+	// Revert of member-user operation after link touches only accounts which are left after link
+	accounts := map[string]bool{Account{FromUser: userID, ToUser: otherID}.String(): true}
+	var reverted []Log
+	for _, record := range relinked {
+		if record.OperationID == 10 {
+			reverted = append(reverted, record)
+		}
+	}
+	assert.NoError(t, checkRevertible(10, reverted))
+	for _, record := range reverted {
+		account := Account{FromUser: record.FromUser, ToUser: record.ToUser}
+		assert.True(t, accounts[account.String()], "record %d is on account %s which is deleted by link", record.ID, account)
+	}
+}
+
+func Test_sameBalances(t *testing.T) {
+	read := []Account{
+		{FromUser: 1, ToUser: 2, Balance: money.New(100, 2)},
+		{FromUser: 1, ToUser: 3, Balance: money.New(0, 2)},
+	}
+	tests := []struct {
+		name   string
+		locked []Account
+		want   bool
+	}{
+		{
+			name:   "unchanged",
+			locked: []Account{{FromUser: 1, ToUser: 2, Balance: money.New(100, 2)}},
+			want:   true,
+		},
+		{
+			name:   "changed balance from the other side",
+			locked: []Account{{FromUser: 2, ToUser: 1, Balance: money.New(-50, 2)}, {FromUser: 3, ToUser: 1, Balance: money.New(0, 2)}},
+			want:   false,
+		},
+		{
+			name:   "other side with equal balance",
+			locked: []Account{{FromUser: 2, ToUser: 1, Balance: money.New(-100, 2)}},
+			want:   true,
+		},
+		{
+			name:   "debt written in between",
+			locked: []Account{{FromUser: 1, ToUser: 2, Balance: money.New(150, 2)}, {FromUser: 1, ToUser: 3, Balance: money.New(0, 2)}},
+			want:   false,
+		},
+		{
+			name:   "new account",
+			locked: []Account{{FromUser: 1, ToUser: 2, Balance: money.New(100, 2)}, {FromUser: 2, ToUser: 3, Balance: money.New(10, 2)}},
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, sameBalances(read, tt.locked))
+		})
+	}
+}
@@ -1,12 +1,51 @@
 package database
 
-import "context"
+import (
+	"context"
+	"moneyjar/pkg/money"
+)
 
 // Provider is database interface
 type Provider interface {
-	CreateUser(ctx context.Context, id int, name string) error
-	UpdateAccounts(ctx context.Context, toAccounts []Account, amount int, comment string) ([]Account, error)
-	UserNameToAccount(ctx context.Context, fromUserID int, toUsername string) (Account, error)
-	GetAccountsWithUser(ctx context.Context, userID int) ([]Account, error)
-	GetTransactionsForUser(ctx context.Context, userID, page int) ([]Log, error)
+	CreateUser(ctx context.Context, chatID int64, user User) ([]Account, error)
+	UpdateUserNames(ctx context.Context, user User) error
+	CreateVirtualUser(ctx context.Context, chatID int64, name string, createdBy int) (User, []Account, error)
+	GetVirtualUser(ctx context.Context, chatID int64, name string) (User, error)
+	LinkVirtualUser(ctx context.Context, chatID int64, name string, userID int) error
+	CreateLedger(ctx context.Context, ledger Ledger) error
+	GetLedger(ctx context.Context, chatID int64) (Ledger, error)
+	RebaseLedger(ctx context.Context, ledger Ledger, rate float64) error
+	SetConfirmDebts(ctx context.Context, chatID int64, enabled bool) error
+	UpdateAccounts(ctx context.Context, op *Operation, transfers []Transfer) ([]Account, error)
+	SettleAccounts(ctx context.Context, op *Operation, accounts []Account, transfers []Transfer) ([]Account, error)
+	RevertOperation(ctx context.Context, op *Operation) ([]Account, error)
+	EditOperation(ctx context.Context, op *Operation, transfers []Transfer) ([]Account, error)
+	CreateProposal(ctx context.Context, proposal *Proposal, op Operation, transfers []Transfer) error
+	SetProposalMessage(ctx context.Context, proposalID int64, messageID int) error
+	ConfirmProposal(ctx context.Context, chatID, proposalID int64, userID int) (Proposal, []Account, error)
+	DeclineProposal(ctx context.Context, chatID, proposalID int64, userID int) (Proposal, error)
+	ExpireProposals(ctx context.Context) ([]Proposal, error)
+	UserNameToAccount(ctx context.Context, chatID int64, fromUserID int, toUsername string) (Account, error)
+	UserIDToAccount(ctx context.Context, chatID int64, fromUserID, toUserID int) (Account, error)
+	GetBalance(ctx context.Context, chatID int64, fromUserID, toUserID int) (money.Amount, error)
+	GetAccountsWithUser(ctx context.Context, chatID int64, userID int) ([]Account, error)
+	GetAccountsInChat(ctx context.Context, chatID int64) ([]Account, error)
+	GetPendingAccounts(ctx context.Context, chatID int64, userID int) ([]Account, error)
+	GetTransactionsForUser(ctx context.Context, chatID int64, userID, page int) ([]Log, error)
+	GetOperation(ctx context.Context, chatID, operationID int64) ([]Log, error)
+	GetLastOperationID(ctx context.Context, chatID int64, authorID int) (int64, error)
+	GetOperationByMessage(ctx context.Context, chatID int64, messageID int) (int64, error)
+	AddOperationMessage(ctx context.Context, chatID int64, messageID int, operationID int64) error
+	DisputeOperation(ctx context.Context, chatID, operationID int64, userID int, reason string) (Dispute, error)
+	GetDispute(ctx context.Context, chatID, operationID int64) (Dispute, error)
+	ResolveDispute(
+		ctx context.Context, chatID, operationID int64, userID int, resolution Resolution, comment string,
+	) (Dispute, error)
+	GetExchangeRates(ctx context.Context) ([]ExchangeRate, error)
+	SaveExchangeRate(ctx context.Context, rate ExchangeRate) error
+	PinRate(ctx context.Context, rate PinnedRate) error
+	UnpinRate(ctx context.Context, chatID int64, currency string) error
+	GetPinnedRates(ctx context.Context, chatID int64) ([]PinnedRate, error)
+	GetUserSettings(ctx context.Context, userID int) (UserSettings, error)
+	SaveUserSettings(ctx context.Context, settings UserSettings) error
 }
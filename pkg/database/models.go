@@ -1,7 +1,9 @@
 package database
 
 import (
+	"database/sql"
 	"fmt"
+	"moneyjar/pkg/money"
 	"sort"
 	"time"
 )
@@ -9,12 +11,16 @@ import (
 // Account represents record in accounts table
 type Account struct {
 	ID           int
+	ChatID       int64  `db:"chat_id"`
 	FromUser     int    `db:"from_user"`
 	FromUserName string `db:"from_user_name"`
 	ToUser       int    `db:"to_user"`
 	ToUserName   string `db:"to_user_name"`
 	IsFlipped    bool   `db:"is_flipped"`
-	Balance      int
+	// Balance is in ledger base currency
+	Balance money.Amount
+	// IsPending account is kept for user who has not registered yet, ToUser is zero and ToUserName is username
+	IsPending bool `db:"is_pending"`
 }
 
 func (a Account) String() string {
@@ -23,17 +29,235 @@ func (a Account) String() string {
 	return fmt.Sprintf("%d:%d", ids[0], ids[1])
 }
 
+// Ledger represents record in ledgers table
+type Ledger struct {
+	ChatID       int64  `db:"chat_id"`
+	BaseCurrency string `db:"base_currency"`
+	BaseDecimals int    `db:"base_decimals"`
+	// ConfirmDebts makes new debts wait for confirmation of debtors before balances change
+	ConfirmDebts bool `db:"confirm_debts"`
+}
+
+// UserSettings represents record in user_settings table
+type UserSettings struct {
+	UserID int `db:"user_id"`
+	// DisplayCurrency is currency balances are shown in, empty means base currency of ledger
+	DisplayCurrency string `db:"display_currency"`
+}
+
 // User represents record in users table
 type User struct {
-	ID   int
+	ID int
+	// Name is Telegram username used in mentions, it's empty for users without username
 	Name string
+	// DisplayName is first and last name, it's shown if user has no username
+	DisplayName string `db:"display_name"`
+	// IsVirtual user is not on Telegram and has negative ID
+	IsVirtual bool `db:"is_virtual"`
+	// CreatedBy is ID of user who added virtual member, zero if it's unknown
+	CreatedBy int `db:"created_by"`
+}
+
+// Kind is a type of transactionLog record
+type Kind string
+
+const (
+	// KindExpense is a debt created by /debt
+	KindExpense Kind = "expense"
+	// KindSettlement is a repayment created by /settle
+	KindSettlement Kind = "settlement"
+	// KindNetting moves debts between accounts without real payment, e.g. after /simplify
+	KindNetting Kind = "netting"
+	// KindRevert compensates records of reverted operation
+	KindRevert Kind = "revert"
+	// KindCorrection compensates records replaced by edit of operation
+	KindCorrection Kind = "correction"
+)
+
+// Operation is a group of transactionLog records written by single command
+type Operation struct {
+	ID       int64
+	ChatID   int64
+	AuthorID int
+	Kind     Kind
+	Comment  string
+	// Reverts is ID of operation compensated by this one
+	Reverts int64
+	// Currency is ISO code of currency entered by author and ExchangeRate is its rate to ledger base currency
+	Currency     string
+	ExchangeRate float64
+	// EffectiveDate is date of backdated expense, zero means date of record
+	EffectiveDate time.Time
+	// RateSource is name of provider of ExchangeRate
+	RateSource string
+	// MessageID is ID of message with command in chat, zero if operation was not written by user message
+	MessageID int
+}
+
+// Transfer is a balance change of single account, amount is added to FromUser side of account
+type Transfer struct {
+	Account Account
+	// Amount is in ledger base currency
+	Amount money.Amount
+	// OriginalAmount is amount in currency of operation before conversion
+	OriginalAmount money.Amount
+	// Kind overrides kind of operation for this transfer
+	Kind Kind
+}
+
+// NeedsConfirmation tells whether target of transfer has to confirm it. Only registered users can press
+// buttons, and only debtors confirm, transfer which credits the target is taken as is.
+func (t Transfer) NeedsConfirmation() bool {
+	return !t.Account.IsPending && t.Account.ToUser > 0 && t.Amount.Sign() > 0
 }
 
 // Log represents record in transactionLog table
 type Log struct {
-	FromUserName  string    `db:"from_user_name"`
-	ToUserName    string    `db:"to_user_name"`
-	BalanceChange int       `db:"balance_change"`
-	Comment       string    `db:"comment"`
-	TS            time.Time `db:"ts"`
+	ID            int64        `db:"id"`
+	OperationID   int64        `db:"operation_id"`
+	AuthorID      int          `db:"author_id"`
+	FromUser      int          `db:"from_user"`
+	FromUserName  string       `db:"from_user_name"`
+	ToUser        int          `db:"to_user"`
+	ToUserName    string       `db:"to_user_name"`
+	Kind          Kind         `db:"kind"`
+	BalanceChange money.Amount `db:"balance_change"`
+	// OriginalAmount, Currency and ExchangeRate are empty for records written before currencies were logged
+	OriginalAmount money.Amount `db:"original_amount"`
+	Currency       string       `db:"currency"`
+	ExchangeRate   float64      `db:"exchange_rate"`
+	RateSource     string       `db:"rate_source"`
+	Comment        string       `db:"comment"`
+	Reverts        int64        `db:"reverts"`
+	Reverted       bool         `db:"reverted"`
+	// Edited operation has previous versions in transactionlog_edits
+	Edited bool `db:"edited"`
+	// Disputed operation has open dispute
+	Disputed bool      `db:"disputed"`
+	TS       time.Time `db:"ts"`
+	// EffectiveDate is date when operation happened, it's date of TS if operation was not backdated
+	EffectiveDate time.Time `db:"effective_date"`
+	// MessageID is ID of message with command operation was written by, zero if it's unknown
+	MessageID int `db:"message_id"`
+	// Superseded record is compensated by correction and is not shown
+	Superseded bool `db:"superseded"`
+}
+
+// PendingTransfer represents record in pending_transfers table, it's a transfer to user who has not registered yet
+type PendingTransfer struct {
+	ID             int64        `db:"id"`
+	ChatID         int64        `db:"chat_id"`
+	OperationID    int64        `db:"operation_id"`
+	AuthorID       int          `db:"author_id"`
+	FromUser       int          `db:"from_user"`
+	ToUsername     string       `db:"to_username"`
+	Kind           Kind         `db:"kind"`
+	Amount         money.Amount `db:"amount"`
+	OriginalAmount money.Amount `db:"original_amount"`
+	Currency       string       `db:"currency"`
+	ExchangeRate   float64      `db:"exchange_rate"`
+	RateSource     string       `db:"rate_source"`
+	EffectiveDate  sql.NullTime `db:"effective_date"`
+	Comment        string       `db:"comment"`
+	MessageID      int          `db:"message_id"`
+}
+
+// ProposalStatus is a state of debt proposal
+type ProposalStatus string
+
+const (
+	// ProposalOpen waits for confirmation of debtors
+	ProposalOpen ProposalStatus = "open"
+	// ProposalAccepted is confirmed by all debtors and written to ledger
+	ProposalAccepted ProposalStatus = "accepted"
+	// ProposalDeclined is rejected by one of debtors or withdrawn by author
+	ProposalDeclined ProposalStatus = "declined"
+	// ProposalExpired was not confirmed in time
+	ProposalExpired ProposalStatus = "expired"
+)
+
+// Proposal represents record in debt_proposals table, it's a debt which changes balances only after
+// all debtors confirm it
+type Proposal struct {
+	ID       int64 `db:"id"`
+	ChatID   int64 `db:"chat_id"`
+	AuthorID int   `db:"author_id"`
+	// MessageID is ID of message with confirmation buttons
+	MessageID int `db:"message_id"`
+	// Description is amount of debt as it's shown to users
+	Description string         `db:"description"`
+	Status      ProposalStatus `db:"status"`
+	// OperationID is ID of operation written when proposal was accepted
+	OperationID sql.NullInt64 `db:"operation_id"`
+	ExpiresAt   time.Time     `db:"expires_at"`
+	// Debtors are users who have to confirm the debt
+	Debtors []Debtor `db:"-"`
+}
+
+// Debtor is a user who has to confirm proposal
+type Debtor struct {
+	UserID int    `db:"user_id"`
+	Name   string `db:"name"`
+	// Amount is share of user in ledger base currency
+	Amount    money.Amount `db:"amount"`
+	Confirmed bool         `db:"confirmed"`
+}
+
+// Resolution is a way dispute was closed
+type Resolution string
+
+const (
+	// ResolutionReverted closes dispute when author reverts disputed operation
+	ResolutionReverted Resolution = "reverted"
+	// ResolutionEdited closes dispute when author edits disputed operation
+	ResolutionEdited Resolution = "edited"
+	// ResolutionAdmin closes dispute by decision of chat admin
+	ResolutionAdmin Resolution = "admin"
+)
+
+// Dispute represents record in disputes table, it's an objection to operation with thread of comments
+type Dispute struct {
+	ID          int64 `db:"id"`
+	ChatID      int64 `db:"chat_id"`
+	OperationID int64 `db:"operation_id"`
+	OpenedBy    int   `db:"opened_by"`
+	// ResolvedBy and Resolution are empty while dispute is open
+	ResolvedBy int          `db:"resolved_by"`
+	Resolution Resolution   `db:"resolution"`
+	CreatedAt  time.Time    `db:"created_at"`
+	ResolvedAt sql.NullTime `db:"resolved_at"`
+	// Comments are objections and replies in order they were written
+	Comments []DisputeComment `db:"-"`
+	// Parties are author and users of disputed operation
+	Parties []User `db:"-"`
+}
+
+// DisputeComment represents record in dispute_comments table
+type DisputeComment struct {
+	AuthorID   int       `db:"author_id"`
+	AuthorName string    `db:"author_name"`
+	Text       string    `db:"text"`
+	CreatedAt  time.Time `db:"created_at"`
+}
+
+// ExchangeRate represents record in exchange_rates table
+type ExchangeRate struct {
+	FromCurrency string       `db:"from_currency"`
+	ToCurrency   string       `db:"to_currency"`
+	Rate         float64      `db:"rate"`
+	Source       string       `db:"source"`
+	UpdatedAt    sql.NullTime `db:"updated_at"`
+	ExpiresAt    time.Time    `db:"expires_at"`
+}
+
+// PinnedRate represents record in pinned_rates table
+type PinnedRate struct {
+	ChatID       int64  `db:"chat_id"`
+	Currency     string `db:"currency"`
+	BaseCurrency string `db:"base_currency"`
+	// Rate is amount of Currency for one unit of BaseCurrency
+	Rate      float64      `db:"rate"`
+	AuthorID  int          `db:"author_id"`
+	CreatedAt time.Time    `db:"created_at"`
+	ExpiresAt sql.NullTime `db:"expires_at"`
 }
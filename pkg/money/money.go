@@ -0,0 +1,303 @@
+package money
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxDecimals limits precision, so units of reasonable amounts fit into int64
+const maxDecimals = 9
+
+// ErrOverflow is returned when units of amount don't fit into int64
+var ErrOverflow = errors.New("amount is too big")
+
+// Amount is exact amount of money with fixed number of decimals, e.g. 1250 units with 2 decimals is 12.50.
+// Amounts with different decimals can be added and compared, result has the bigger number of decimals.
+type Amount struct {
+	units    int64
+	decimals int
+}
+
+// New returns amount of units with decimals digits after point
+func New(units int64, decimals int) Amount {
+	return Amount{units: units, decimals: decimals}
+}
+
+// Parse parses decimal number like "-12.50", number of decimals is taken as written
+func Parse(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	integer, fraction := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		integer, fraction = s[:i], s[i+1:]
+	}
+	if len(fraction) > maxDecimals {
+		return Amount{}, fmt.Errorf("amount %q has more than %d decimals", s, maxDecimals)
+	}
+	if strings.ContainsAny(fraction, "+-") {
+		return Amount{}, fmt.Errorf("bad amount %q", s)
+	}
+
+	units, err := strconv.ParseInt(integer+fraction, 10, 64)
+	if err != nil {
+		return Amount{}, fmt.Errorf("bad amount %q: %v", s, err)
+	}
+	return Amount{units: units, decimals: len(fraction)}, nil
+}
+
+// FromRat rounds rational number half away from zero to amount with decimals, ErrOverflow is returned
+// if units of the result don't fit into int64
+func FromRat(r *big.Rat, decimals int) (Amount, error) {
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt(pow10(decimals)))
+	quo, rem := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	// Remainder is at least half of denominator
+	if new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(scaled.Denom()) >= 0 {
+		quo.Add(quo, big.NewInt(int64(rem.Sign())))
+	}
+	if !quo.IsInt64() {
+		return Amount{}, fmt.Errorf("%w: %s with %d decimals", ErrOverflow, r.FloatString(decimals), decimals)
+	}
+	return Amount{units: quo.Int64(), decimals: decimals}, nil
+}
+
+// FromFloat rounds float to amount with decimals, it's meant for values which are floats anyway like rates
+func FromFloat(f float64, decimals int) (Amount, error) {
+	r, ok := new(big.Rat).SetString(strconv.FormatFloat(f, 'f', -1, 64))
+	if !ok {
+		return Amount{}, fmt.Errorf("bad amount %v", f)
+	}
+	return FromRat(r, decimals)
+}
+
+// Units returns amount in minor units
+func (a Amount) Units() int64 {
+	return a.units
+}
+
+// Decimals returns number of digits after point
+func (a Amount) Decimals() int {
+	return a.decimals
+}
+
+// IsZero reports if amount is zero
+func (a Amount) IsZero() bool {
+	return a.units == 0
+}
+
+// Sign returns -1, 0 or 1 for negative, zero and positive amount
+func (a Amount) Sign() int {
+	switch {
+	case a.units < 0:
+		return -1
+	case a.units > 0:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Neg returns amount with opposite sign
+func (a Amount) Neg() Amount {
+	return Amount{units: -a.units, decimals: a.decimals}
+}
+
+// Abs returns absolute value of amount
+func (a Amount) Abs() Amount {
+	if a.units < 0 {
+		return a.Neg()
+	}
+	return a
+}
+
+// Add returns sum of amounts, ErrOverflow is returned if it doesn't fit into int64 units
+func (a Amount) Add(b Amount) (Amount, error) {
+	a, b, err := align(a, b)
+	if err != nil {
+		return Amount{}, err
+	}
+	sum := a.units + b.units
+	// Sum of amounts with the same sign has the same sign unless it wraps around
+	if (a.units > 0 && b.units > 0 && sum < 0) || (a.units < 0 && b.units < 0 && sum >= 0) {
+		return Amount{}, fmt.Errorf("%w: %s + %s", ErrOverflow, a, b)
+	}
+	return Amount{units: sum, decimals: a.decimals}, nil
+}
+
+// Sub returns difference of amounts, ErrOverflow is returned if it doesn't fit into int64 units
+func (a Amount) Sub(b Amount) (Amount, error) {
+	if b.units == math.MinInt64 {
+		return Amount{}, fmt.Errorf("%w: %s - %s", ErrOverflow, a, b)
+	}
+	return a.Add(b.Neg())
+}
+
+// Cmp compares amounts and returns -1, 0 or 1 if a is less, equal or greater than b
+func (a Amount) Cmp(b Amount) int {
+	if a.decimals == b.decimals {
+		switch {
+		case a.units < b.units:
+			return -1
+		case a.units > b.units:
+			return 1
+		default:
+			return 0
+		}
+	}
+	return a.Rat().Cmp(b.Rat())
+}
+
+// Mul multiplies amount by r and rounds result to decimals
+func (a Amount) Mul(r *big.Rat, decimals int) (Amount, error) {
+	return FromRat(new(big.Rat).Mul(a.Rat(), r), decimals)
+}
+
+// Rescale changes number of decimals, amount is rounded half away from zero if decimals are dropped.
+// ErrOverflow is returned if added decimals don't fit into int64 units.
+func (a Amount) Rescale(decimals int) (Amount, error) {
+	if decimals >= a.decimals {
+		units := new(big.Int).Mul(big.NewInt(a.units), pow10(decimals-a.decimals))
+		if !units.IsInt64() {
+			return Amount{}, fmt.Errorf("%w: %s with %d decimals", ErrOverflow, a, decimals)
+		}
+		return Amount{units: units.Int64(), decimals: decimals}, nil
+	}
+	return FromRat(a.Rat(), decimals)
+}
+
+// Reduce drops trailing zero decimals, e.g. 12.50 becomes 12.5 and 3.00 becomes 3
+func (a Amount) Reduce() Amount {
+	for a.decimals > 0 && a.units%10 == 0 {
+		a.units /= 10
+		a.decimals--
+	}
+	return a
+}
+
+// Allocate splits amount in proportion to weights using largest remainder method: every part is rounded down
+// to minor units and units left are given one by one to parts with the largest dropped fractions, ties go to
+// the part with smaller index. Parts always sum up to amount exactly. Weights must not be negative,
+// all parts are zero if weights sum up to zero.
+func Allocate(a Amount, weights []*big.Rat) []Amount {
+	parts := make([]Amount, len(weights))
+	sum := new(big.Rat)
+	for _, w := range weights {
+		sum.Add(sum, w)
+	}
+	if sum.Sign() == 0 {
+		for i := range parts {
+			parts[i] = Amount{decimals: a.decimals}
+		}
+		return parts
+	}
+
+	total := a.Abs()
+	remainders := make([]*big.Rat, len(weights))
+	left := total.units
+	for i, w := range weights {
+		exact := new(big.Rat).Mul(big.NewRat(total.units, 1), w)
+		exact.Quo(exact, sum)
+		units := new(big.Int).Quo(exact.Num(), exact.Denom())
+		remainders[i] = exact.Sub(exact, new(big.Rat).SetInt(units))
+		parts[i] = Amount{units: units.Int64(), decimals: a.decimals}
+		left -= parts[i].units
+	}
+
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return remainders[order[i]].Cmp(remainders[order[j]]) > 0
+	})
+	for i := int64(0); i < left; i++ {
+		parts[order[i]].units++
+	}
+
+	if a.units < 0 {
+		for i := range parts {
+			parts[i] = parts[i].Neg()
+		}
+	}
+	return parts
+}
+
+// Rat returns amount as rational number
+func (a Amount) Rat() *big.Rat {
+	return new(big.Rat).SetFrac(big.NewInt(a.units), pow10(a.decimals))
+}
+
+// String returns amount with all its decimals, e.g. "-12.50"
+func (a Amount) String() string {
+	units := strconv.FormatInt(a.units, 10)
+	if a.decimals == 0 {
+		return units
+	}
+
+	sign := ""
+	if a.units < 0 {
+		sign, units = "-", units[1:]
+	}
+	if len(units) <= a.decimals {
+		units = strings.Repeat("0", a.decimals-len(units)+1) + units
+	}
+	point := len(units) - a.decimals
+	return sign + units[:point] + "." + units[point:]
+}
+
+// Scan implements sql.Scanner, amounts are stored as numeric
+func (a *Amount) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*a = Amount{}
+	case int64:
+		*a = Amount{units: v}
+	case []byte:
+		return a.Scan(string(v))
+	case string:
+		if i := strings.IndexByte(v, '.'); i >= 0 && len(v)-i-1 > maxDecimals {
+			// Old records can have float noise like 0.30000000000000004
+			r, ok := new(big.Rat).SetString(v)
+			if !ok {
+				return fmt.Errorf("bad amount %q", v)
+			}
+			rounded, err := FromRat(r, maxDecimals)
+			if err != nil {
+				return err
+			}
+			*a = rounded
+			return nil
+		}
+		parsed, err := Parse(v)
+		if err != nil {
+			return err
+		}
+		*a = parsed
+	default:
+		return fmt.Errorf("can not scan %T to amount", src)
+	}
+	return nil
+}
+
+// Value implements driver.Valuer
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+func align(a, b Amount) (Amount, Amount, error) {
+	var err error
+	if a.decimals > b.decimals {
+		b, err = b.Rescale(a.decimals)
+	} else {
+		a, err = a.Rescale(b.decimals)
+	}
+	return a, b, err
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
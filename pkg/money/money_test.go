@@ -0,0 +1,256 @@
+package money
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+	"testing/quick"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Amount
+		wantErr bool
+	}{
+		{input: "12.50", want: New(1250, 2)},
+		{input: "-0.05", want: New(-5, 2)},
+		{input: "100", want: New(100, 0)},
+		{input: ".5", want: New(5, 1)},
+		{input: "1.234", want: New(1234, 3)},
+		{input: "abc", wantErr: true},
+		{input: "1.-5", wantErr: true},
+		{input: "1.0000000001", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := Parse(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAmount_String(t *testing.T) {
+	assert.Equal(t, "12.50", New(1250, 2).String())
+	assert.Equal(t, "-0.05", New(-5, 2).String())
+	assert.Equal(t, "0.000", New(0, 3).String())
+	assert.Equal(t, "1000", New(1000, 0).String())
+	assert.Equal(t, "-1.005", New(-1005, 3).String())
+}
+
+func TestAmount_Arithmetic(t *testing.T) {
+	// 0.1 + 0.2 is exactly 0.3, unlike floats
+	sum, err := New(1, 1).Add(New(2, 1))
+	assert.NoError(t, err)
+	assert.Equal(t, New(3, 1), sum)
+	assert.Equal(t, 0, New(30, 2).Cmp(New(3, 1)))
+
+	// Result has the bigger number of decimals
+	sum, err = New(125, 2).Add(New(5, 3))
+	assert.NoError(t, err)
+	assert.Equal(t, New(1255, 3), sum)
+	diff, err := New(250, 2).Sub(New(10, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, New(-750, 2), diff)
+	assert.Equal(t, -1, New(-1, 2).Cmp(New(0, 0)))
+	assert.Equal(t, New(5, 0), New(-5, 0).Abs())
+
+	// Amounts too far apart to be subtracted are still compared
+	assert.Equal(t, 1, New(math.MaxInt64, 0).Cmp(New(-1, 0)))
+}
+
+func TestAmount_overflow(t *testing.T) {
+	// 123456789012345678 with 2 decimals used to wrap around to a negative amount
+	r, _ := new(big.Rat).SetString("123456789012345678")
+	_, err := FromRat(r, 2)
+	assert.True(t, errors.Is(err, ErrOverflow), "FromRat() error = %v", err)
+
+	r, _ = new(big.Rat).SetString("99999999999999999999")
+	_, err = FromRat(r, 0)
+	assert.True(t, errors.Is(err, ErrOverflow), "FromRat() error = %v", err)
+
+	_, err = New(math.MaxInt64, 0).Add(New(1, 0))
+	assert.True(t, errors.Is(err, ErrOverflow), "Add() error = %v", err)
+	_, err = New(math.MinInt64+1, 0).Sub(New(2, 0))
+	assert.True(t, errors.Is(err, ErrOverflow), "Sub() error = %v", err)
+
+	_, err = New(math.MaxInt64/10, 0).Rescale(2)
+	assert.True(t, errors.Is(err, ErrOverflow), "Rescale() error = %v", err)
+	// Mixed decimals are aligned before adding
+	_, err = New(math.MaxInt64/10, 0).Add(New(1, 2))
+	assert.True(t, errors.Is(err, ErrOverflow), "Add() error = %v", err)
+
+	_, err = New(math.MaxInt64, 2).Mul(big.NewRat(2, 1), 2)
+	assert.True(t, errors.Is(err, ErrOverflow), "Mul() error = %v", err)
+
+	sum, err := New(math.MaxInt64-1, 0).Add(New(1, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, New(math.MaxInt64, 0), sum)
+}
+
+func TestAmount_Rescale(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   Amount
+		decimals int
+		want     Amount
+	}{
+		{name: "more decimals", amount: New(125, 2), decimals: 3, want: New(1250, 3)},
+		{name: "round down", amount: New(1234, 3), decimals: 2, want: New(123, 2)},
+		{name: "round half up", amount: New(1235, 3), decimals: 2, want: New(124, 2)},
+		{name: "round half away from zero", amount: New(-1235, 3), decimals: 2, want: New(-124, 2)},
+		{name: "to zero decimals", amount: New(1050, 2), decimals: 0, want: New(11, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.amount.Rescale(tt.decimals)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAmount_Mul(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   Amount
+		r        *big.Rat
+		decimals int
+		want     Amount
+	}{
+		{name: "500 GEL at 0.3704 is 185.20 USD", amount: New(50000, 2), r: big.NewRat(3704, 10000), decimals: 2, want: New(18520, 2)},
+		{name: "100 JPY without decimals at 0.0067 is 0.67 USD", amount: New(100, 0), r: big.NewRat(67, 10000), decimals: 2, want: New(67, 2)},
+		{name: "a third of 100.00", amount: New(10000, 2), r: big.NewRat(1, 3), decimals: 2, want: New(3333, 2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.amount.Mul(tt.r, tt.decimals)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFromFloat(t *testing.T) {
+	got, err := FromFloat(0.37, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, New(370, 3), got)
+	got, err = FromFloat(-0.005, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, New(-1, 2), got)
+}
+
+func TestAmount_Reduce(t *testing.T) {
+	assert.Equal(t, New(125, 1), New(1250, 2).Reduce())
+	assert.Equal(t, New(3, 0), New(300, 2).Reduce())
+	assert.Equal(t, New(0, 0), New(0, 3).Reduce())
+	assert.Equal(t, New(-1, 1), New(-10, 2).Reduce())
+}
+
+func TestAllocate(t *testing.T) {
+	third := big.NewRat(1, 3)
+	tests := []struct {
+		name    string
+		amount  Amount
+		weights []*big.Rat
+		want    []Amount
+	}{
+		{
+			name:    "equal parts, tie goes to the first one",
+			amount:  New(10000, 2),
+			weights: []*big.Rat{third, third, third},
+			want:    []Amount{New(3334, 2), New(3333, 2), New(3333, 2)},
+		},
+		{
+			name:    "negative amount",
+			amount:  New(-10000, 2),
+			weights: []*big.Rat{third, third, third},
+			want:    []Amount{New(-3334, 2), New(-3333, 2), New(-3333, 2)},
+		},
+		{
+			name:    "largest remainder",
+			amount:  New(100, 0),
+			weights: []*big.Rat{big.NewRat(1, 1), big.NewRat(2, 1), big.NewRat(3, 1)},
+			want:    []Amount{New(17, 0), New(33, 0), New(50, 0)},
+		},
+		{
+			name:    "zero weights",
+			amount:  New(100, 2),
+			weights: []*big.Rat{new(big.Rat), new(big.Rat)},
+			want:    []Amount{New(0, 2), New(0, 2)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Allocate(tt.amount, tt.weights))
+		})
+	}
+}
+
+func TestAllocate_properties(t *testing.T) {
+	allocate := func(units int32, decimals uint8, rawWeights []uint16) bool {
+		if len(rawWeights) == 0 {
+			return true
+		}
+		amount := New(int64(units), int(decimals%4))
+		weights := make([]*big.Rat, len(rawWeights))
+		sum := new(big.Rat)
+		for i, w := range rawWeights {
+			weights[i] = big.NewRat(int64(w), 1)
+			sum.Add(sum, weights[i])
+		}
+		if sum.Sign() == 0 {
+			return true
+		}
+
+		parts := Allocate(amount, weights)
+		total := New(0, amount.Decimals())
+		for i, part := range parts {
+			// Every part is off from its exact share by less than one minor unit
+			exact := new(big.Rat).Mul(amount.Rat(), weights[i])
+			exact.Quo(exact, sum)
+			diff := new(big.Rat).Sub(part.Rat(), exact)
+			if diff.Abs(diff).Cmp(New(1, amount.Decimals()).Rat()) >= 0 {
+				return false
+			}
+			if part.Decimals() != amount.Decimals() || (part.Sign() != 0 && part.Sign() != amount.Sign()) {
+				return false
+			}
+			var err error
+			if total, err = total.Add(part); err != nil {
+				return false
+			}
+		}
+		return total.Cmp(amount) == 0
+	}
+	assert.NoError(t, quick.Check(allocate, &quick.Config{MaxCount: 1000}))
+}
+
+func TestAmount_Scan(t *testing.T) {
+	var a Amount
+	assert.NoError(t, a.Scan([]byte("-12.50")))
+	assert.Equal(t, New(-1250, 2), a)
+
+	assert.NoError(t, a.Scan(int64(7)))
+	assert.Equal(t, New(7, 0), a)
+
+	assert.NoError(t, a.Scan(nil))
+	assert.True(t, a.IsZero())
+
+	assert.NoError(t, a.Scan("0.30000000000000004"))
+	assert.Equal(t, New(300000000, 9), a)
+
+	assert.Error(t, a.Scan(1.5))
+
+	value, err := New(1250, 2).Value()
+	assert.NoError(t, err)
+	assert.Equal(t, "12.50", value)
+}
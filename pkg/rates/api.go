@@ -0,0 +1,77 @@
+package rates
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	apiSource   = "exchangerate-api"
+	apiEndpoint = "https://v6.exchangerate-api.com/v6/%s/pair/%s/%s"
+)
+
+// ExchangeRateResponse represents models of API response
+type ExchangeRateResponse struct {
+	Result             string  `json:"result"`
+	Documentation      string  `json:"documentation"`
+	TermsOfUse         string  `json:"terms_of_use"`
+	TimeLastUpdateUnix int     `json:"time_last_update_unix"`
+	TimeLastUpdateUtc  string  `json:"time_last_update_utc"`
+	TimeNextUpdateUnix int     `json:"time_next_update_unix"`
+	TimeNextUpdateUtc  string  `json:"time_next_update_utc"`
+	BaseCode           string  `json:"base_code"`
+	TargetCode         string  `json:"target_code"`
+	ConversionRate     float64 `json:"conversion_rate"`
+	ConversionResult   float64 `json:"conversion_result"`
+}
+
+// API gets rates from exchangerate-api.com
+type API struct {
+	apiKey     string
+	httpClient HTTPClient
+}
+
+// NewAPI returns new API provider
+func NewAPI(apiKey string, httpClient HTTPClient) *API {
+	return &API{
+		apiKey:     apiKey,
+		httpClient: httpClient,
+	}
+}
+
+// Rate implements Provider
+func (a API) Rate(ctx context.Context, from, to string) (Quote, error) {
+	url := fmt.Sprintf(apiEndpoint, a.apiKey, from, to)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Quote{}, err
+	}
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return Quote{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Quote{}, fmt.Errorf("api returned bad status: %v", resp.StatusCode)
+	}
+
+	var exchangeRateResp ExchangeRateResponse
+	if err = json.NewDecoder(resp.Body).Decode(&exchangeRateResp); err != nil {
+		return Quote{}, err
+	}
+	if exchangeRateResp.Result != "success" {
+		return Quote{}, fmt.Errorf("api returned bad result: %s", exchangeRateResp.Result)
+	}
+
+	return Quote{
+		From:         from,
+		To:           to,
+		Rate:         exchangeRateResp.ConversionRate,
+		Source:       apiSource,
+		UpdatedAt:    time.Unix(int64(exchangeRateResp.TimeLastUpdateUnix), 0),
+		NextUpdateAt: time.Unix(int64(exchangeRateResp.TimeNextUpdateUnix), 0),
+	}, nil
+}
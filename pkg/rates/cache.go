@@ -0,0 +1,210 @@
+package rates
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Store persists cached rates, so they survive restarts
+type Store interface {
+	GetExchangeRates(ctx context.Context) ([]StoredRate, error)
+	SaveExchangeRate(ctx context.Context, rate StoredRate) error
+}
+
+// StoredRate is a cached rate as it's kept in Store
+type StoredRate struct {
+	From   string
+	To     string
+	Rate   float64
+	Source string
+	// UpdatedAt is zero if upstream did not tell when the rate was published
+	UpdatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// CachedQuote is a quote stored in Cache
+type CachedQuote struct {
+	Quote
+	ExpiresAt time.Time
+}
+
+// CacheStats are counters of Cache lookups
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+	// Stale is number of misses served with expired rate because upstream failed
+	Stale uint64
+}
+
+// Cache keeps rates until upstream publishes new ones. If upstream fails, expired rate is returned marked as stale,
+// so providers which never fail, like Static, should be asked only after Cache.
+type Cache struct {
+	provider Provider
+	store    Store
+	// ttl is used when upstream does not tell time of the next update
+	ttl time.Duration
+	now func() time.Time
+
+	mu     sync.RWMutex
+	quotes map[string]CachedQuote
+	// history keeps rates of past days, they never change, so they are not expired nor persisted
+	history map[string]Quote
+
+	hits   uint64
+	misses uint64
+	stale  uint64
+}
+
+// NewCache returns new Cache on top of provider
+func NewCache(provider Provider, store Store, ttl time.Duration) *Cache {
+	return &Cache{
+		provider: provider,
+		store:    store,
+		ttl:      ttl,
+		now:      time.Now,
+		quotes:   make(map[string]CachedQuote),
+		history:  make(map[string]Quote),
+	}
+}
+
+// Load fills cache with persisted rates
+func (c *Cache) Load(ctx context.Context) error {
+	exchangeRates, err := c.store.GetExchangeRates(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, r := range exchangeRates {
+		quote := CachedQuote{
+			Quote: Quote{
+				From:      r.From,
+				To:        r.To,
+				Rate:      r.Rate,
+				Source:    r.Source,
+				UpdatedAt: r.UpdatedAt,
+			},
+			ExpiresAt: r.ExpiresAt,
+		}
+		c.quotes[cacheKey(r.From, r.To)] = quote
+	}
+	return nil
+}
+
+// Rate implements Provider
+func (c *Cache) Rate(ctx context.Context, from, to string) (Quote, error) {
+	key := cacheKey(from, to)
+
+	c.mu.RLock()
+	cached, found := c.quotes[key]
+	c.mu.RUnlock()
+
+	if found && c.now().Before(cached.ExpiresAt) {
+		atomic.AddUint64(&c.hits, 1)
+		return cached.Quote, nil
+	}
+	atomic.AddUint64(&c.misses, 1)
+
+	quote, err := c.provider.Rate(ctx, from, to)
+	if err != nil {
+		if !found {
+			return Quote{}, err
+		}
+		atomic.AddUint64(&c.stale, 1)
+		log.Warnf("serving stale rate %s expired at %v: %v", key, cached.ExpiresAt, err)
+		stale := cached.Quote
+		stale.Stale = true
+		return stale, nil
+	}
+
+	expiresAt := quote.NextUpdateAt
+	if expiresAt.Before(c.now()) {
+		expiresAt = c.now().Add(c.ttl)
+	}
+	c.mu.Lock()
+	c.quotes[key] = CachedQuote{Quote: quote, ExpiresAt: expiresAt}
+	c.mu.Unlock()
+
+	storedRate := StoredRate{
+		From:      from,
+		To:        to,
+		Rate:      quote.Rate,
+		Source:    quote.Source,
+		UpdatedAt: quote.UpdatedAt,
+		ExpiresAt: expiresAt,
+	}
+	if err = c.store.SaveExchangeRate(ctx, storedRate); err != nil {
+		// Rate is still good to use, it just won't survive restart
+		log.Errorf("failed to persist rate %s: %v", key, err)
+	}
+	return quote, nil
+}
+
+// RateAt implements HistoricalProvider, it fails if underlying provider has no historical rates
+func (c *Cache) RateAt(ctx context.Context, from, to string, date time.Time) (Quote, error) {
+	historical, ok := c.provider.(HistoricalProvider)
+	if !ok {
+		return Quote{}, fmt.Errorf("rate provider %T has no historical rates", c.provider)
+	}
+
+	day := date.Format(ecbDayLayout)
+	key := cacheKey(from, to) + "@" + day
+
+	c.mu.RLock()
+	cached, found := c.history[key]
+	c.mu.RUnlock()
+
+	if found {
+		atomic.AddUint64(&c.hits, 1)
+		return cached, nil
+	}
+	atomic.AddUint64(&c.misses, 1)
+
+	quote, err := historical.RateAt(ctx, from, to, date)
+	if err != nil {
+		return Quote{}, err
+	}
+
+	// Rate of today can still be published later
+	if day < c.now().Format(ecbDayLayout) {
+		c.mu.Lock()
+		c.history[key] = quote
+		c.mu.Unlock()
+	}
+	return quote, nil
+}
+
+// Quotes returns all cached quotes sorted by currencies
+func (c *Cache) Quotes() []CachedQuote {
+	c.mu.RLock()
+	quotes := make([]CachedQuote, 0, len(c.quotes))
+	for _, quote := range c.quotes {
+		quotes = append(quotes, quote)
+	}
+	c.mu.RUnlock()
+
+	sort.Slice(quotes, func(i, j int) bool {
+		return cacheKey(quotes[i].From, quotes[i].To) < cacheKey(quotes[j].From, quotes[j].To)
+	})
+	return quotes
+}
+
+// Stats returns lookup counters
+func (c *Cache) Stats() CacheStats {
+	return CacheStats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+		Stale:  atomic.LoadUint64(&c.stale),
+	}
+}
+
+func cacheKey(from, to string) string {
+	return fmt.Sprintf("%s/%s", from, to)
+}
@@ -0,0 +1,63 @@
+package rates
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Chain asks providers one by one and returns the first rate found
+type Chain struct {
+	providers []Provider
+}
+
+// NewChain returns new Chain of providers
+func NewChain(providers ...Provider) *Chain {
+	return &Chain{providers: providers}
+}
+
+// Rate implements Provider
+func (c Chain) Rate(ctx context.Context, from, to string) (Quote, error) {
+	if from == to {
+		return Quote{From: from, To: to, Rate: 1}, nil
+	}
+
+	var errs []string
+	for _, provider := range c.providers {
+		quote, err := provider.Rate(ctx, from, to)
+		if err == nil {
+			return quote, nil
+		}
+		log.Warnf("rate provider %T failed to get %s/%s: %v", provider, from, to, err)
+		errs = append(errs, err.Error())
+	}
+	return Quote{}, fmt.Errorf("all rate providers failed: %s", strings.Join(errs, "; "))
+}
+
+// RateAt implements HistoricalProvider, providers without historical rates are skipped
+func (c Chain) RateAt(ctx context.Context, from, to string, date time.Time) (Quote, error) {
+	if from == to {
+		return Quote{From: from, To: to, Rate: 1}, nil
+	}
+
+	var errs []string
+	for _, provider := range c.providers {
+		historical, ok := provider.(HistoricalProvider)
+		if !ok {
+			continue
+		}
+		quote, err := historical.RateAt(ctx, from, to, date)
+		if err == nil {
+			return quote, nil
+		}
+		log.Warnf("rate provider %T failed to get %s/%s at %s: %v", provider, from, to, date.Format(ecbDayLayout), err)
+		errs = append(errs, err.Error())
+	}
+	if len(errs) == 0 {
+		return Quote{}, fmt.Errorf("no rate providers with historical rates")
+	}
+	return Quote{}, fmt.Errorf("all rate providers failed: %s", strings.Join(errs, "; "))
+}
@@ -0,0 +1,124 @@
+package rates
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	ecbSource   = "ecb"
+	ecbEndpoint = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-daily.xml"
+	// ecbHistEndpoint has rates of the last 90 days, newest first
+	ecbHistEndpoint = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-hist-90d.xml"
+	ecbBase         = "EUR"
+	ecbDayLayout    = "2006-01-02"
+)
+
+// ecbEnvelope represents daily reference rates feed of European Central Bank
+type ecbEnvelope struct {
+	Cube struct {
+		Days []ecbDay `xml:"Cube"`
+	} `xml:"Cube"`
+}
+
+type ecbDay struct {
+	Time  string `xml:"time,attr"`
+	Rates []struct {
+		Currency string  `xml:"currency,attr"`
+		Rate     float64 `xml:"rate,attr"`
+	} `xml:"Cube"`
+}
+
+// ECB gets rates from daily XML feed of European Central Bank, all rates are crossed through EUR
+type ECB struct {
+	httpClient HTTPClient
+}
+
+// NewECB returns new ECB provider
+func NewECB(httpClient HTTPClient) *ECB {
+	return &ECB{httpClient: httpClient}
+}
+
+// Rate implements Provider
+func (e ECB) Rate(ctx context.Context, from, to string) (Quote, error) {
+	envelope, err := e.fetch(ctx, ecbEndpoint)
+	if err != nil {
+		return Quote{}, err
+	}
+	if len(envelope.Cube.Days) == 0 {
+		return Quote{}, fmt.Errorf("ecb feed has no rates")
+	}
+	return crossRate(envelope.Cube.Days[0], from, to)
+}
+
+// RateAt implements HistoricalProvider. ECB does not publish rates on weekends and holidays,
+// so rate of the previous working day is returned for them.
+func (e ECB) RateAt(ctx context.Context, from, to string, date time.Time) (Quote, error) {
+	envelope, err := e.fetch(ctx, ecbHistEndpoint)
+	if err != nil {
+		return Quote{}, err
+	}
+
+	day := date.Format(ecbDayLayout)
+	for _, d := range envelope.Cube.Days {
+		// Days are sorted from newest and layout is sortable as string
+		if d.Time <= day {
+			return crossRate(d, from, to)
+		}
+	}
+	return Quote{}, fmt.Errorf("ecb has no rates for %s", day)
+}
+
+func (e ECB) fetch(ctx context.Context, url string) (ecbEnvelope, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return ecbEnvelope{}, err
+	}
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return ecbEnvelope{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ecbEnvelope{}, fmt.Errorf("ecb returned bad status: %v", resp.StatusCode)
+	}
+
+	var envelope ecbEnvelope
+	if err = xml.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return ecbEnvelope{}, fmt.Errorf("failed to decode ecb feed: %v", err)
+	}
+	return envelope, nil
+}
+
+// crossRate computes rate between two currencies from their rates to EUR
+func crossRate(day ecbDay, from, to string) (Quote, error) {
+	eurRates := map[string]float64{ecbBase: 1}
+	for _, r := range day.Rates {
+		eurRates[r.Currency] = r.Rate
+	}
+
+	fromRate, ok := eurRates[from]
+	if !ok || fromRate == 0 {
+		return Quote{}, fmt.Errorf("ecb has no rate for %s", from)
+	}
+	toRate, ok := eurRates[to]
+	if !ok {
+		return Quote{}, fmt.Errorf("ecb has no rate for %s", to)
+	}
+
+	updatedAt, err := time.Parse(ecbDayLayout, day.Time)
+	if err != nil {
+		return Quote{}, fmt.Errorf("failed to parse ecb date: %v", err)
+	}
+
+	return Quote{
+		From:      from,
+		To:        to,
+		Rate:      toRate / fromRate,
+		Source:    ecbSource,
+		UpdatedAt: updatedAt,
+	}, nil
+}
@@ -0,0 +1,41 @@
+package rates
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Quote is exchange rate between two currencies
+type Quote struct {
+	From string
+	To   string
+	// Rate is amount of To currency for one unit of From currency
+	Rate float64
+	// Source is name of provider which returned the rate
+	Source string
+	// UpdatedAt is time when the rate was published by upstream
+	UpdatedAt time.Time
+	// NextUpdateAt is time when upstream publishes new rate, it's zero if unknown
+	NextUpdateAt time.Time
+	// Stale is set when upstream failed and outdated rate was taken from cache
+	Stale bool
+	// Fallback is set when rate of requested date is not available and current rate is used instead
+	Fallback bool
+}
+
+// Provider returns exchange rates, currencies are passed as ISO codes
+type Provider interface {
+	Rate(ctx context.Context, from, to string) (Quote, error)
+}
+
+// HistoricalProvider returns exchange rates of past dates
+type HistoricalProvider interface {
+	// RateAt returns the last rate published on or before date
+	RateAt(ctx context.Context, from, to string, date time.Time) (Quote, error)
+}
+
+// HTTPClient represents http.Client interface
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
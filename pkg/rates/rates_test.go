@@ -0,0 +1,291 @@
+package rates
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type stubClient struct {
+	status int
+	body   string
+	err    error
+}
+
+func (s stubClient) Do(*http.Request) (*http.Response, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &http.Response{StatusCode: s.status, Body: io.NopCloser(strings.NewReader(s.body))}, nil
+}
+
+type failingProvider struct{}
+
+func (failingProvider) Rate(context.Context, string, string) (Quote, error) {
+	return Quote{}, errors.New("upstream is down")
+}
+
+const ecbFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<gesmes:subject>Reference rates</gesmes:subject>
+	<Cube>
+		<Cube time="2026-10-16">
+			<Cube currency="USD" rate="1.25"/>
+			<Cube currency="GBP" rate="0.5"/>
+		</Cube>
+	</Cube>
+</gesmes:Envelope>`
+
+func TestAPI_Rate(t *testing.T) {
+	body := `{"result":"success","time_last_update_unix":1700000000,"time_next_update_unix":1700086400,` +
+		`"base_code":"GEL","target_code":"USD","conversion_rate":0.3704}`
+	api := NewAPI("key", stubClient{status: http.StatusOK, body: body})
+
+	quote, err := api.Rate(context.Background(), "GEL", "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.3704, quote.Rate)
+	assert.Equal(t, int64(1700086400), quote.NextUpdateAt.Unix())
+
+	_, err = NewAPI("key", stubClient{status: http.StatusForbidden}).Rate(context.Background(), "GEL", "USD")
+	assert.Error(t, err)
+}
+
+func TestECB_Rate(t *testing.T) {
+	ecb := NewECB(stubClient{status: http.StatusOK, body: ecbFeed})
+
+	quote, err := ecb.Rate(context.Background(), "GBP", "USD")
+	assert.NoError(t, err)
+	assert.InDelta(t, 2.5, quote.Rate, 1e-9)
+	assert.Equal(t, "2026-10-16", quote.UpdatedAt.Format(ecbDayLayout))
+
+	quote, err = ecb.Rate(context.Background(), "EUR", "USD")
+	assert.NoError(t, err)
+	assert.InDelta(t, 1.25, quote.Rate, 1e-9)
+
+	_, err = ecb.Rate(context.Background(), "GEL", "USD")
+	assert.Error(t, err)
+}
+
+const ecbHistFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<gesmes:subject>Reference rates</gesmes:subject>
+	<Cube>
+		<Cube time="2026-10-16">
+			<Cube currency="USD" rate="1.25"/>
+		</Cube>
+		<Cube time="2026-10-09">
+			<Cube currency="USD" rate="1.2"/>
+		</Cube>
+		<Cube time="2026-10-08">
+			<Cube currency="USD" rate="1.1"/>
+		</Cube>
+	</Cube>
+</gesmes:Envelope>`
+
+func TestECB_RateAt(t *testing.T) {
+	ecb := NewECB(stubClient{status: http.StatusOK, body: ecbHistFeed})
+
+	tests := []struct {
+		name    string
+		date    time.Time
+		want    float64
+		wantDay string
+		wantErr bool
+	}{
+		{name: "published day", date: time.Date(2026, 10, 8, 0, 0, 0, 0, time.UTC), want: 1.1, wantDay: "2026-10-08"},
+		{name: "weekend", date: time.Date(2026, 10, 11, 15, 0, 0, 0, time.UTC), want: 1.2, wantDay: "2026-10-09"},
+		{name: "after last day", date: time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC), want: 1.25, wantDay: "2026-10-16"},
+		{name: "before first day", date: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quote, err := ecb.RateAt(context.Background(), "EUR", "USD", tt.date)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.InDelta(t, tt.want, quote.Rate, 1e-9)
+			assert.Equal(t, tt.wantDay, quote.UpdatedAt.Format(ecbDayLayout))
+		})
+	}
+}
+
+func TestStatic_Rate(t *testing.T) {
+	static := NewStatic("USD", map[string]float64{"GEL": 2.7, "RUB": 90})
+
+	quote, err := static.Rate(context.Background(), "GEL", "USD")
+	assert.NoError(t, err)
+	assert.InDelta(t, 1/2.7, quote.Rate, 1e-9)
+
+	quote, err = static.Rate(context.Background(), "GEL", "RUB")
+	assert.NoError(t, err)
+	assert.InDelta(t, 90/2.7, quote.Rate, 1e-9)
+
+	_, err = static.Rate(context.Background(), "EUR", "USD")
+	assert.Error(t, err)
+}
+
+func TestChain_Rate(t *testing.T) {
+	chain := NewChain(failingProvider{}, NewStatic("USD", map[string]float64{"GEL": 2.5}))
+
+	quote, err := chain.Rate(context.Background(), "GEL", "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, staticSource, quote.Source)
+	assert.InDelta(t, 0.4, quote.Rate, 1e-9)
+
+	_, err = NewChain(failingProvider{}).Rate(context.Background(), "GEL", "USD")
+	assert.Error(t, err)
+}
+
+func TestChain_RateAt(t *testing.T) {
+	date := time.Date(2026, 10, 9, 0, 0, 0, 0, time.UTC)
+	chain := NewChain(failingProvider{}, NewECB(stubClient{status: http.StatusOK, body: ecbHistFeed}))
+
+	quote, err := chain.RateAt(context.Background(), "EUR", "USD", date)
+	assert.NoError(t, err)
+	assert.Equal(t, ecbSource, quote.Source)
+	assert.InDelta(t, 1.2, quote.Rate, 1e-9)
+
+	// Providers without historical rates are skipped, fixed rates are not rates of any date
+	_, err = NewChain(failingProvider{}).RateAt(context.Background(), "EUR", "USD", date)
+	assert.Error(t, err)
+	_, err = NewChain(NewStatic("USD", map[string]float64{"EUR": 0.9})).RateAt(context.Background(), "EUR", "USD", date)
+	assert.Error(t, err)
+}
+
+type memoryStore struct {
+	rates []StoredRate
+}
+
+func (m *memoryStore) GetExchangeRates(context.Context) ([]StoredRate, error) {
+	return m.rates, nil
+}
+
+func (m *memoryStore) SaveExchangeRate(_ context.Context, rate StoredRate) error {
+	m.rates = append(m.rates, rate)
+	return nil
+}
+
+type switchProvider struct {
+	quote Quote
+	err   error
+	calls int
+}
+
+func (s *switchProvider) Rate(context.Context, string, string) (Quote, error) {
+	s.calls++
+	return s.quote, s.err
+}
+
+func TestCache_Rate(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	provider := &switchProvider{quote: Quote{From: "GEL", To: "USD", Rate: 0.37, NextUpdateAt: now.Add(time.Hour)}}
+	store := &memoryStore{}
+
+	cache := NewCache(provider, store, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	quote, err := cache.Rate(context.Background(), "GEL", "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.37, quote.Rate)
+	assert.Len(t, store.rates, 1)
+
+	// Upstream said the next update is in an hour, so the rate is served from cache
+	now = now.Add(30 * time.Minute)
+	_, err = cache.Rate(context.Background(), "GEL", "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, provider.calls)
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 1}, cache.Stats())
+
+	// After expiration failing upstream leads to stale rate
+	now = now.Add(time.Hour)
+	provider.err = errors.New("upstream is down")
+	quote, err = cache.Rate(context.Background(), "GEL", "USD")
+	assert.NoError(t, err)
+	assert.True(t, quote.Stale)
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 2, Stale: 1}, cache.Stats())
+
+	_, err = cache.Rate(context.Background(), "RUB", "USD")
+	assert.Error(t, err)
+}
+
+func TestCache_beforeStatic(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	store := &memoryStore{rates: []StoredRate{
+		{From: "GEL", To: "USD", Rate: 0.37, Source: apiSource, ExpiresAt: now.Add(-time.Hour)},
+	}}
+	cache := NewCache(failingProvider{}, store, time.Minute)
+	cache.now = func() time.Time { return now }
+	assert.NoError(t, cache.Load(context.Background()))
+	chain := NewChain(cache, NewStatic("USD", map[string]float64{"GEL": 2.5, "RUB": 90}))
+
+	// Last market rate is preferred to fixed one
+	quote, err := chain.Rate(context.Background(), "GEL", "USD")
+	assert.NoError(t, err)
+	assert.True(t, quote.Stale)
+	assert.Equal(t, 0.37, quote.Rate)
+
+	// Fixed rate is used only if nothing was cached
+	quote, err = chain.Rate(context.Background(), "RUB", "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, staticSource, quote.Source)
+}
+
+func TestCache_RateAt(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	client := &countingClient{stubClient: stubClient{status: http.StatusOK, body: ecbHistFeed}}
+
+	cache := NewCache(NewECB(client), &memoryStore{}, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		quote, err := cache.RateAt(context.Background(), "EUR", "USD", time.Date(2026, 10, 9, 0, 0, 0, 0, time.UTC))
+		assert.NoError(t, err)
+		assert.InDelta(t, 1.2, quote.Rate, 1e-9)
+	}
+	assert.Equal(t, 1, client.calls)
+
+	// Today's rate is not cached as it can be published later
+	for i := 0; i < 2; i++ {
+		_, err := cache.RateAt(context.Background(), "EUR", "USD", now)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 3, client.calls)
+
+	_, err := NewCache(&switchProvider{}, &memoryStore{}, time.Minute).RateAt(context.Background(), "EUR", "USD", now)
+	assert.Error(t, err)
+}
+
+type countingClient struct {
+	stubClient
+	calls int
+}
+
+func (c *countingClient) Do(req *http.Request) (*http.Response, error) {
+	c.calls++
+	return c.stubClient.Do(req)
+}
+
+func TestCache_Load(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	store := &memoryStore{rates: []StoredRate{
+		{From: "GEL", To: "USD", Rate: 0.37, Source: apiSource, ExpiresAt: now.Add(time.Hour)},
+	}}
+	provider := &switchProvider{err: errors.New("must not be called")}
+
+	cache := NewCache(provider, store, time.Minute)
+	cache.now = func() time.Time { return now }
+	assert.NoError(t, cache.Load(context.Background()))
+
+	quote, err := cache.Rate(context.Background(), "GEL", "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.37, quote.Rate)
+	assert.Equal(t, 0, provider.calls)
+}
@@ -0,0 +1,52 @@
+package rates
+
+import (
+	"context"
+	"fmt"
+)
+
+const staticSource = "static"
+
+// Static returns fixed rates from config, it never fails for known currencies, so it's good as last resort.
+// It has no historical rates, fixed rate is not the rate of any particular date.
+type Static struct {
+	base  string
+	rates map[string]float64
+}
+
+// NewStatic returns new Static provider, rates are amounts of currency for one unit of base currency
+func NewStatic(base string, rates map[string]float64) *Static {
+	return &Static{
+		base:  base,
+		rates: rates,
+	}
+}
+
+// Rate implements Provider
+func (s Static) Rate(_ context.Context, from, to string) (Quote, error) {
+	fromRate, err := s.baseRate(from)
+	if err != nil {
+		return Quote{}, err
+	}
+	toRate, err := s.baseRate(to)
+	if err != nil {
+		return Quote{}, err
+	}
+	return Quote{
+		From:   from,
+		To:     to,
+		Rate:   toRate / fromRate,
+		Source: staticSource,
+	}, nil
+}
+
+func (s Static) baseRate(cur string) (float64, error) {
+	if cur == s.base {
+		return 1, nil
+	}
+	rate, ok := s.rates[cur]
+	if !ok || rate <= 0 {
+		return 0, fmt.Errorf("no static rate for %s", cur)
+	}
+	return rate, nil
+}